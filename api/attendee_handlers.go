@@ -0,0 +1,75 @@
+package main
+
+import (
+	"events-app/data/models"
+	"net/http"
+	"strconv"
+)
+
+func (app *application) listAttendees(w http.ResponseWriter, r *http.Request) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	params, err := queryParamsFromURL(r.URL.Query())
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	// Only ever list the attendees of the event in the path
+	params["eventId"] = strconv.FormatInt(id, 10)
+
+	page, err := readPage(params)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	attendees, err := app.Repo.QueryAttendeesContext(r.Context(), params)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+	if err := app.finishPage(r.Context(), &page, models.Attendee{}, params, attendees); err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	app.sendPage(w, r, page, attendees, "attendees")
+}
+
+// rsvp registers the authenticated user as attending the event.
+func (app *application) rsvp(w http.ResponseWriter, r *http.Request) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a, _ := actorFromContext(r.Context())
+	attendee, err := app.Repo.RSVPContext(r.Context(), id, a.ID)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	app.SendSuccessJSON(w, http.StatusCreated, attendee, "attendee")
+}
+
+// cancelRSVP cancels the authenticated user's RSVP to the event.
+func (app *application) cancelRSVP(w http.ResponseWriter, r *http.Request) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a, _ := actorFromContext(r.Context())
+	if err := app.Repo.CancelRSVPContext(r.Context(), id, a.ID); err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	app.SendSuccessJSON(w, http.StatusOK, nil)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"events-app/data/models"
+	"events-app/data/repository"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRSVP(t *testing.T) {
+	attendee := func(status string) models.Attendee {
+		return models.Attendee{
+			ID:        1,
+			EventID:   1,
+			UserID:    2,
+			Status:    status,
+			CreatedAt: time.Now().UTC().Truncate(time.Second),
+		}
+	}
+	expectSeatCheck := func(mock sqlmock.Sqlmock, confirmed, waitlisted int) {
+		mock.ExpectQuery("SELECT COUNT\\(\\*\\) FILTER (.+) FROM attendees").
+			WithArgs(1, models.AttendeeConfirmed, models.AttendeeWaitlisted).
+			WillReturnRows(sqlmock.NewRows([]string{"confirmed", "waitlisted"}).AddRow(confirmed, waitlisted))
+	}
+
+	tests := []struct {
+		name           string
+		setup          func(mock sqlmock.Sqlmock)
+		expectedStatus int
+		expectedRSVP   string
+	}{
+		{
+			name: "Seat available",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT max_attendees FROM events WHERE id = \\$1 FOR UPDATE").
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"max_attendees"}).AddRow(2))
+				mock.ExpectQuery("SELECT status FROM attendees").
+					WithArgs(1, 2).
+					WillReturnError(sql.ErrNoRows)
+				expectSeatCheck(mock, 1, 0)
+				mock.ExpectQuery("INSERT INTO attendees").
+					WithArgs(1, 2, models.AttendeeConfirmed).
+					WillReturnRows(mockRows(attendee(models.AttendeeConfirmed)))
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusCreated,
+			expectedRSVP:   models.AttendeeConfirmed,
+		},
+		{
+			name: "Event full",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT max_attendees FROM events WHERE id = \\$1 FOR UPDATE").
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"max_attendees"}).AddRow(2))
+				mock.ExpectQuery("SELECT status FROM attendees").
+					WithArgs(1, 2).
+					WillReturnError(sql.ErrNoRows)
+				expectSeatCheck(mock, 2, 0)
+				mock.ExpectQuery("INSERT INTO attendees").
+					WithArgs(1, 2, models.AttendeeWaitlisted).
+					WillReturnRows(mockRows(attendee(models.AttendeeWaitlisted)))
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusCreated,
+			expectedRSVP:   models.AttendeeWaitlisted,
+		},
+		{
+			name: "Seats free but owed to those waiting",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT max_attendees FROM events WHERE id = \\$1 FOR UPDATE").
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"max_attendees"}).AddRow(5))
+				mock.ExpectQuery("SELECT status FROM attendees").
+					WithArgs(1, 2).
+					WillReturnError(sql.ErrNoRows)
+				expectSeatCheck(mock, 2, 3)
+				mock.ExpectQuery("INSERT INTO attendees").
+					WithArgs(1, 2, models.AttendeeWaitlisted).
+					WillReturnRows(mockRows(attendee(models.AttendeeWaitlisted)))
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusCreated,
+			expectedRSVP:   models.AttendeeWaitlisted,
+		},
+		{
+			name: "Seats to spare after those waiting",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT max_attendees FROM events WHERE id = \\$1 FOR UPDATE").
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"max_attendees"}).AddRow(5))
+				mock.ExpectQuery("SELECT status FROM attendees").
+					WithArgs(1, 2).
+					WillReturnError(sql.ErrNoRows)
+				expectSeatCheck(mock, 2, 2)
+				mock.ExpectQuery("INSERT INTO attendees").
+					WithArgs(1, 2, models.AttendeeConfirmed).
+					WillReturnRows(mockRows(attendee(models.AttendeeConfirmed)))
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusCreated,
+			expectedRSVP:   models.AttendeeConfirmed,
+		},
+		{
+			name: "Already waitlisted",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT max_attendees FROM events WHERE id = \\$1 FOR UPDATE").
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"max_attendees"}).AddRow(2))
+				mock.ExpectQuery("SELECT status FROM attendees").
+					WithArgs(1, 2).
+					WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.AttendeeWaitlisted))
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name: "Missing event",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT max_attendees FROM events WHERE id = \\$1 FOR UPDATE").
+					WithArgs(1).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			tt.setup(mock)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/events/1/rsvp", nil)
+			app.routes().ServeHTTP(w, authorize(t, app, req, 2))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			if tt.expectedStatus == http.StatusCreated {
+				var res struct {
+					Data struct {
+						Attendee models.Attendee `json:"attendee"`
+					} `json:"data"`
+				}
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+				assert.Equal(t, tt.expectedRSVP, res.Data.Attendee.Status)
+			}
+		})
+	}
+}
+
+func TestRSVPRetriesSerializationFailures(t *testing.T) {
+	conflict := &pgconn.PgError{Code: pgerrcode.SerializationFailure}
+	attempt := func(mock sqlmock.Sqlmock, commitErr error) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT max_attendees FROM events WHERE id = \\$1 FOR UPDATE").
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"max_attendees"}).AddRow(2))
+		mock.ExpectQuery("SELECT status FROM attendees").
+			WithArgs(1, 2).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("SELECT COUNT\\(\\*\\) FILTER (.+) FROM attendees").
+			WillReturnRows(sqlmock.NewRows([]string{"confirmed", "waitlisted"}).AddRow(0, 0))
+		mock.ExpectQuery("INSERT INTO attendees").
+			WillReturnRows(mockRows(models.Attendee{ID: 1, EventID: 1, UserID: 2, Status: models.AttendeeConfirmed}))
+		mock.ExpectCommit().WillReturnError(commitErr)
+	}
+
+	tests := []struct {
+		name           string
+		maxRetries     int
+		commitErrs     []error
+		expectedStatus int
+	}{
+		{"Succeeds on retry", 3, []error{conflict, conflict, nil}, http.StatusCreated},
+		{"Gives up after MaxTxRetries", 1, []error{conflict, conflict}, http.StatusInternalServerError},
+		{"Retries disabled", 0, []error{conflict}, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			app.Repo.(*repository.SqlRepo).MaxTxRetries = tt.maxRetries
+			for _, err := range tt.commitErrs {
+				attempt(mock, err)
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/events/1/rsvp", nil)
+			app.routes().ServeHTTP(w, authorize(t, app, req, 2))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCancelRSVP(t *testing.T) {
+	tests := []struct {
+		name           string
+		setup          func(mock sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			name: "Confirmed, first waitlisted user is promoted",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT status FROM attendees").
+					WithArgs(1, 2).
+					WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.AttendeeConfirmed))
+				mock.ExpectExec("UPDATE attendees SET status = \\$1 WHERE event_id").
+					WithArgs(models.AttendeeCancelled, 1, 2).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM attendees").
+					WithArgs(1, models.AttendeeConfirmed).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+				mock.ExpectExec("UPDATE attendees SET status = \\$1 WHERE id IN \\((.+)ORDER BY created_at, id LIMIT \\$4").
+					WithArgs(models.AttendeeConfirmed, 1, models.AttendeeWaitlisted, sql.NullInt64{Int64: 1, Valid: true}).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Waitlisted, nobody is promoted",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT status FROM attendees").
+					WithArgs(1, 2).
+					WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.AttendeeWaitlisted))
+				mock.ExpectExec("UPDATE attendees SET status = \\$1 WHERE event_id").
+					WithArgs(models.AttendeeCancelled, 1, 2).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Not attending",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT status FROM attendees").
+					WithArgs(1, 2).
+					WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.AttendeeCancelled))
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT max_attendees FROM events WHERE id = \\$1 FOR UPDATE").
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"max_attendees"}).AddRow(2))
+			tt.setup(mock)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodDelete, "/events/1/rsvp", nil)
+			app.routes().ServeHTTP(w, authorize(t, app, req, 2))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestListAttendees(t *testing.T) {
+	app, mock := newTestApp(t)
+	mock.ExpectQuery("SELECT (.+) FROM attendees WHERE event_id = \\$1 ORDER BY id ASC").
+		WithArgs(1, 10, 0).
+		WillReturnRows(mockRows(models.Attendee{ID: 1, EventID: 1, UserID: 2, Status: models.AttendeeConfirmed}))
+
+	w := httptest.NewRecorder()
+	// an eventId in the query must not override the one in the path
+	app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events/1/attendees?eventId=5", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
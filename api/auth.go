@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"events-app/data/models"
+	"events-app/data/repository"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	tokenIssuer     = "events-app"
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 7 * 24 * time.Hour
+)
+
+var (
+	errInvalidToken        = errors.New("invalid or expired token")
+	errInvalidRefreshToken = errors.New("invalid or expired refresh token")
+)
+
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// accessClaims are the claims carried by an access token. The user's role is
+// included so authorization checks don't need to hit the db on every request.
+type accessClaims struct {
+	Role string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+type tokenPair struct {
+	AccessToken           string    `json:"accessToken"`
+	AccessTokenExpiresAt  time.Time `json:"accessTokenExpiresAt"`
+	RefreshToken          string    `json:"refreshToken"`
+	RefreshTokenExpiresAt time.Time `json:"refreshTokenExpiresAt"`
+}
+
+// issueTokens signs a new access token for the user and stores a new refresh
+// token for them.
+func (app *application) issueTokens(ctx context.Context, user models.User) (tokenPair, error) {
+	var pair tokenPair
+	var err error
+
+	pair.AccessToken, pair.AccessTokenExpiresAt, err = app.newAccessToken(actor{ID: user.ID, Role: user.Role})
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	pair.RefreshToken, err = generateRandomToken()
+	if err != nil {
+		return tokenPair{}, err
+	}
+	pair.RefreshTokenExpiresAt = time.Now().UTC().Add(refreshTokenTTL)
+
+	_, err = app.Repo.CreateContext(ctx, models.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(pair.RefreshToken),
+		ExpiresAt: pair.RefreshTokenExpiresAt,
+	})
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	return pair, nil
+}
+
+// newAccessToken returns a signed JWT identifying the user, along with the
+// time it expires.
+func (app *application) newAccessToken(a actor) (string, time.Time, error) {
+	now := time.Now().UTC()
+	expiresAt := now.Add(accessTokenTTL)
+
+	claims := accessClaims{
+		Role: a.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   strconv.FormatInt(a.ID, 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(app.JWTSecret))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error signing access token: %v", err)
+	}
+
+	return token, expiresAt, nil
+}
+
+// parseAccessToken verifies the signature and expiry of an access token and
+// returns the user it was issued to.
+func (app *application) parseAccessToken(tokenString string) (actor, error) {
+	claims := accessClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
+		return []byte(app.JWTSecret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return actor{}, errInvalidToken
+	}
+
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return actor{}, errInvalidToken
+	}
+	return actor{ID: userID, Role: claims.Role}, nil
+}
+
+// generateRandomToken returns 32 bytes of randomness encoded for use in URLs
+// and JSON.
+func generateRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex encoded SHA-256 of a token. Refresh tokens are
+// random enough that a fast hash is sufficient.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// revokeRefreshToken revokes the refresh token matching the plaintext token,
+// returning errInvalidRefreshToken if it is unknown, revoked or expired.
+func (app *application) revokeRefreshToken(ctx context.Context, plaintext string) (models.RefreshToken, error) {
+	token, err := app.Repo.RevokeRefreshTokenContext(ctx, hashToken(plaintext))
+	if errors.Is(err, repository.ErrNotFound) {
+		return models.RefreshToken{}, errInvalidRefreshToken
+	}
+	return token, err
+}
+
+func (app *application) login(w http.ResponseWriter, r *http.Request) {
+	var creds credentials
+	if err := app.ReadJSON(w, r, &creds, false); err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := app.Repo.VerifyCredentialsContext(r.Context(), creds.Email, creds.Password)
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidCredentials) {
+			app.SendErrorJSON(w, http.StatusUnauthorized, err)
+			return
+		}
+		app.sendRepoError(w, err)
+		return
+	}
+
+	tokens, err := app.issueTokens(r.Context(), user)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	app.SendSuccessJSON(w, http.StatusOK, tokens, "tokens")
+}
+
+// refresh exchanges a refresh token for a new token pair. The old refresh
+// token is revoked, so each one can only be used once, even by requests that
+// race each other.
+func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := app.ReadJSON(w, r, &req, false); err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	token, err := app.revokeRefreshToken(r.Context(), req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, errInvalidRefreshToken) {
+			app.SendErrorJSON(w, http.StatusUnauthorized, err)
+			return
+		}
+		app.sendRepoError(w, err)
+		return
+	}
+
+	// Reload the user so the new access token carries their current role
+	user, err := app.Repo.GetUserByIDContext(r.Context(), token.UserID)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	tokens, err := app.issueTokens(r.Context(), user)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	app.SendSuccessJSON(w, http.StatusOK, tokens, "tokens")
+}
+
+// logout revokes a refresh token. Access tokens are short lived and simply
+// expire.
+func (app *application) logout(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := app.ReadJSON(w, r, &req, false); err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := app.revokeRefreshToken(r.Context(), req.RefreshToken); err != nil {
+		if errors.Is(err, errInvalidRefreshToken) {
+			// Nothing to revoke; logging out twice is not an error
+			app.SendSuccessJSON(w, http.StatusOK, nil)
+			return
+		}
+		app.sendRepoError(w, err)
+		return
+	}
+
+	app.SendSuccessJSON(w, http.StatusOK, nil)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"events-app/data/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func testUser(t *testing.T) models.User {
+	u := models.User{
+		ID:        1,
+		Email:     "hello@example.com",
+		Password:  "password",
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Role:      models.RoleUser,
+	}
+	if err := u.HashPassword(); err != nil {
+		t.Fatalf("could not hash password: %s", err)
+	}
+	return u
+}
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		setup          func(mock sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			name: "Valid credentials",
+			body: `{"email": "hello@example.com", "password": "password"}`,
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM users WHERE email = \\$1").
+					WithArgs("hello@example.com").
+					WillReturnRows(mockRows(testUser(t)))
+				mock.ExpectPrepare("INSERT INTO refresh_tokens").
+					ExpectQuery().
+					WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg(), false).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Wrong password",
+			body: `{"email": "hello@example.com", "password": "wrong password"}`,
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM users WHERE email = \\$1").
+					WithArgs("hello@example.com").
+					WillReturnRows(mockRows(testUser(t)))
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Unknown email",
+			body: `{"email": "nobody@example.com", "password": "password"}`,
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM users WHERE email = \\$1").
+					WithArgs("nobody@example.com").
+					WillReturnError(sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Malformed body",
+			body:           `{"email": }`,
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			tt.setup(mock)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewBufferString(tt.body))
+			app.routes().ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			if tt.expectedStatus == http.StatusOK {
+				var res struct {
+					Data struct {
+						Tokens tokenPair `json:"tokens"`
+					} `json:"data"`
+				}
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+				assert.NotEmpty(t, res.Data.Tokens.RefreshToken)
+
+				a, err := app.parseAccessToken(res.Data.Tokens.AccessToken)
+				assert.NoError(t, err)
+				assert.Equal(t, actor{ID: 1, Role: models.RoleUser}, a)
+			}
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	plaintext := "a-refresh-token"
+	stored := models.RefreshToken{
+		ID:        1,
+		UserID:    1,
+		TokenHash: hashToken(plaintext),
+		ExpiresAt: time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	revoke := "UPDATE refresh_tokens SET revoked = true WHERE token_hash = \\$1 AND NOT revoked AND expires_at > now\\(\\) RETURNING"
+
+	tests := []struct {
+		name           string
+		setup          func(mock sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			name: "Active token is rotated",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(revoke).
+					WithArgs(hashToken(plaintext)).
+					WillReturnRows(mockRows(stored))
+				mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1").
+					WithArgs(1).
+					WillReturnRows(mockRows(testUser(t)))
+				mock.ExpectPrepare("INSERT INTO refresh_tokens").
+					ExpectQuery().
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			// Whether it is unknown, expired, revoked, or was just used by
+			// another request, no row comes back
+			name: "Inactive token",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(revoke).
+					WithArgs(hashToken(plaintext)).
+					WillReturnError(sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			tt.setup(mock)
+
+			w := httptest.NewRecorder()
+			body := bytes.NewBufferString(`{"refreshToken": "` + plaintext + `"}`)
+			app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/refresh", body))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	app, _ := newTestApp(t)
+	other := &application{JWTSecret: "another-secret"}
+	foreignToken, _, _ := other.newAccessToken(actor{ID: 1})
+
+	var got actor
+	handler := app.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = actorFromContext(r.Context())
+	})
+
+	tests := []struct {
+		name           string
+		header         string
+		expectedStatus int
+	}{
+		{"No header", "", http.StatusUnauthorized},
+		{"Wrong scheme", "Basic dXNlcjpwYXNz", http.StatusUnauthorized},
+		{"Garbage token", "Bearer not-a-jwt", http.StatusUnauthorized},
+		{"Signed with another secret", "Bearer " + foreignToken, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+
+	t.Run("Valid token", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := authorizeAs(t, app, httptest.NewRequest(http.MethodGet, "/", nil), actor{ID: 42, Role: models.RoleAdmin})
+		handler(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, actor{ID: 42, Role: models.RoleAdmin}, got)
+	})
+}
+
+func TestDebugVars(t *testing.T) {
+	tests := []struct {
+		name           string
+		actor          *actor
+		expectedStatus int
+	}{
+		{"Anonymous", nil, http.StatusUnauthorized},
+		{"User", &actor{ID: 1, Role: models.RoleUser}, http.StatusForbidden},
+		{"Admin", &actor{ID: 2, Role: models.RoleAdmin}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newTestApp(t)
+
+			req := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+			if tt.actor != nil {
+				req = authorizeAs(t, app, req, *tt.actor)
+			}
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusOK {
+				assert.Contains(t, w.Body.String(), `"transactions"`)
+			}
+		})
+	}
+}
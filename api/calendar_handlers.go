@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"events-app/data/models"
+	"events-app/data/repository"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// calendarFeedLimit is how many events a calendar feed lists if its URL
+// doesn't set a limit. Clients subscribed to a feed can't page through it.
+const calendarFeedLimit = 500
+
+var errInvalidCalendarToken = errors.New("the calendar feed token is missing or invalid")
+
+// calendarFeed is the URL of a user's calendar feed; the token in it is all
+// that's needed to read the feed, so it is only shown once.
+type calendarFeed struct {
+	URL string `json:"url"`
+}
+
+// exportEvent handles GET /events/{id}.ics, which the mux routes to getEvent,
+// writing the event as an iCalendar file.
+func (app *application) exportEvent(w http.ResponseWriter, r *http.Request) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	event, err := app.Repo.GetEventByIDContext(r.Context(), id)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+	var overrides []models.OccurrenceOverride
+	if event.RRule != "" {
+		byEvent, err := app.Repo.QueryOccurrenceOverridesContext(r.Context(), []int64{id})
+		if err != nil {
+			app.sendRepoError(w, err)
+			return
+		}
+		overrides = byEvent[id]
+	}
+
+	b := newCalendarBuilder("")
+	b.addEvent(event, overrides)
+	app.sendCalendar(w, b)
+}
+
+// userCalendar handles GET /users/{id}/calendar.ics?token=..., the feed of
+// events the user owns or is attending, for calendar clients to subscribe
+// to. Clients can't log in, so the token from createCalendarToken stands in
+// for it. The feed takes the same filters as GET /events.
+func (app *application) userCalendar(w http.ResponseWriter, r *http.Request) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	params, err := queryParamsFromURL(r.URL.Query())
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	token := params["token"]
+	delete(params, "token")
+
+	// A user without a feed gets the same answer as a wrong token, so the
+	// feed doesn't reveal who has one
+	hash, err := app.Repo.GetCalendarTokenHashContext(r.Context(), id)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		app.sendRepoError(w, err)
+		return
+	}
+	if err != nil || token == "" || subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(hash)) != 1 {
+		app.SendErrorJSON(w, http.StatusUnauthorized, errInvalidCalendarToken)
+		return
+	}
+
+	loc, ok := app.readLocation(w, r)
+	if !ok {
+		return
+	}
+	if loc != nil {
+		params["tz"] = loc.String()
+	}
+	if _, ok := params["limit"]; !ok {
+		params["limit"] = strconv.Itoa(calendarFeedLimit)
+	}
+
+	events, err := app.Repo.QueryUserEventsContext(r.Context(), id, params)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+	var recurring []int64
+	for _, e := range events {
+		if e.RRule != "" {
+			recurring = append(recurring, e.ID)
+		}
+	}
+	overrides, err := app.Repo.QueryOccurrenceOverridesContext(r.Context(), recurring)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	b := newCalendarBuilder("Events")
+	for _, e := range events {
+		b.addEvent(e, overrides[e.ID])
+	}
+	app.sendCalendar(w, b)
+}
+
+// createCalendarToken handles POST /users/{id}/calendar/token, returning the
+// URL of the user's calendar feed. Each call issues a new token, so the URL
+// handed out before stops working.
+func (app *application) createCalendarToken(w http.ResponseWriter, r *http.Request) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := app.Repo.GetUserByIDContext(r.Context(), id)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	if !app.authorize(w, r, canModifyUser(user)) {
+		return
+	}
+
+	token, err := generateRandomToken()
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusInternalServerError, errServerError)
+		return
+	}
+	if err := app.Repo.SaveCalendarTokenHashContext(r.Context(), id, hashToken(token)); err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	feed := calendarFeed{URL: fmt.Sprintf("%s://%s/users/%d/calendar.ics?token=%s", scheme, r.Host, id, token)}
+	app.SendSuccessJSON(w, http.StatusCreated, feed, "calendar")
+}
+
+// importEvents handles POST /events/import, creating events for the caller
+// from the iCalendar file sent as the body, or as the file field of a
+// multipart form. See importEntries.
+func (app *application) importEvents(w http.ResponseWriter, r *http.Request) {
+	body, err := importFile(w, r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	defer body.Close()
+
+	entries, err := parseCalendar(body)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	app.importAndReport(w, r, entries)
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"events-app/data/models"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportEvent(t *testing.T) {
+	berlin := func() models.Event {
+		e := weeklyEvent()
+		e.Timezone = "Europe/Berlin"
+		return e
+	}
+	allDay := func() models.Event {
+		e := testEvent()
+		e.AllDay = true
+		e.Timezone = "Asia/Tokyo"
+		e.StartDate = time.Date(2024, 10, 1, 0, 0, 0, 0, time.FixedZone("JST", 9*60*60))
+		e.EndDate = e.StartDate.AddDate(0, 0, 2)
+		return e
+	}
+
+	tests := []struct {
+		name          string
+		event         func() models.Event
+		overrides     []models.OccurrenceOverride
+		expectedLines []string
+	}{
+		{
+			name:  "Single event",
+			event: testEvent,
+			expectedLines: []string{
+				"BEGIN:VCALENDAR",
+				"PRODID:" + calendarProdID,
+				"BEGIN:VEVENT",
+				"UID:event-1@events-app",
+				"DTSTART:" + testEvent().StartDate.Format("20060102T150405Z"),
+				"DTEND:" + testEvent().EndDate.Format("20060102T150405Z"),
+				"SUMMARY:Test Event",
+				"DESCRIPTION:At the manor hotel",
+				"SEQUENCE:2",
+				"END:VCALENDAR",
+			},
+		},
+		{
+			name:  "All-day event",
+			event: allDay,
+			expectedLines: []string{
+				"DTSTART;VALUE=DATE:20241001",
+				"DTEND;VALUE=DATE:20241003",
+			},
+		},
+		{
+			name:  "Recurring event in UTC",
+			event: weeklyEvent,
+			overrides: []models.OccurrenceOverride{
+				{EventID: 1, OriginalStart: weeklyEvent().StartDate.AddDate(0, 0, 7), Cancelled: true},
+			},
+			expectedLines: []string{
+				"DTSTART:20241001T180000Z",
+				"RRULE:FREQ=WEEKLY;COUNT=6",
+				"EXDATE:20241008T180000Z",
+			},
+		},
+		{
+			name:  "Recurring event in its own zone",
+			event: berlin,
+			overrides: []models.OccurrenceOverride{
+				{EventID: 1, OriginalStart: weeklyEvent().StartDate.AddDate(0, 0, 14), Cancelled: true},
+				{
+					EventID:       1,
+					OriginalStart: weeklyEvent().StartDate.AddDate(0, 0, 7),
+					StartDate:     weeklyEvent().StartDate.AddDate(0, 0, 8),
+					EndDate:       weeklyEvent().EndDate.AddDate(0, 0, 8),
+				},
+			},
+			expectedLines: []string{
+				"BEGIN:VTIMEZONE",
+				"TZID:Europe/Berlin",
+				"TZOFFSETFROM:+0200",
+				"TZOFFSETTO:+0100",
+				"DTSTART;TZID=Europe/Berlin:20241001T200000",
+				"EXDATE;TZID=Europe/Berlin:20241015T200000",
+				"RECURRENCE-ID;TZID=Europe/Berlin:20241008T200000",
+				"DTSTART;TZID=Europe/Berlin:20241009T200000",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			e := tt.event()
+			mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+				WithArgs(1).
+				WillReturnRows(mockRows(e))
+			if e.RRule != "" {
+				rows := sqlmock.NewRows(models.GetColumnNames(models.OccurrenceOverride{}, false))
+				if len(tt.overrides) > 0 {
+					overrides := make([]models.Model, len(tt.overrides))
+					for i, o := range tt.overrides {
+						overrides[i] = o
+					}
+					rows = mockRows(overrides...)
+				}
+				mock.ExpectQuery("SELECT (.+) FROM occurrence_overrides WHERE event_id IN \\(\\$1\\)").
+					WithArgs(e.ID).
+					WillReturnRows(rows)
+			}
+
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events/1.ics", nil))
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "text/calendar; charset=utf-8", w.Header().Get("Content-Type"))
+			assert.NoError(t, mock.ExpectationsWereMet())
+			lines := strings.Split(w.Body.String(), "\r\n")
+			for _, line := range tt.expectedLines {
+				assert.Contains(t, lines, line)
+			}
+		})
+	}
+
+	t.Run("Missing event", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+			WithArgs(2).
+			WillReturnError(sql.ErrNoRows)
+
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events/2.ics", nil))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestUserCalendar(t *testing.T) {
+	const token = "feed-token"
+
+	tests := []struct {
+		name           string
+		query          string
+		storedHash     string
+		expectedStatus int
+	}{
+		{"Valid token", "?token=" + token, hashToken(token), http.StatusOK},
+		{"Valid token with filters", "?token=" + token + "&name_contains=Test&limit=5", hashToken(token), http.StatusOK},
+		{"Wrong token", "?token=guess", hashToken(token), http.StatusUnauthorized},
+		{"Missing token", "", hashToken(token), http.StatusUnauthorized},
+		{"No feed", "?token=" + token, "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			lookup := mock.ExpectQuery("SELECT token_hash FROM calendar_feeds WHERE user_id = \\$1").
+				WithArgs(1)
+			if tt.storedHash == "" {
+				lookup.WillReturnError(sql.ErrNoRows)
+			} else {
+				lookup.WillReturnRows(sqlmock.NewRows([]string{"token_hash"}).AddRow(tt.storedHash))
+			}
+			if tt.expectedStatus == http.StatusOK {
+				mock.ExpectQuery("SELECT (.+) FROM \\(SELECT \\* FROM events WHERE user_id = \\$(\\d) OR id IN \\((.+)attendees WHERE user_id = \\$(\\d) AND status IN (.+)\\) AS events").
+					WillReturnRows(mockRows(testEvent()))
+			}
+
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/1/calendar.ics"+tt.query, nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+			if tt.expectedStatus == http.StatusOK {
+				assert.Contains(t, w.Body.String(), "X-WR-CALNAME:Events")
+				assert.Contains(t, w.Body.String(), "UID:event-1@events-app")
+			}
+		})
+	}
+}
+
+func TestCreateCalendarToken(t *testing.T) {
+	tests := []struct {
+		name           string
+		userID         int64
+		expectedStatus int
+	}{
+		{"Own calendar", 1, http.StatusCreated},
+		{"Someone else's calendar", 2, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1").
+				WithArgs(1).
+				WillReturnRows(mockRows(testUser(t)))
+			if tt.expectedStatus == http.StatusCreated {
+				mock.ExpectExec("INSERT INTO calendar_feeds (.+) ON CONFLICT \\(user_id\\) DO UPDATE").
+					WithArgs(1, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/users/1/calendar/token", nil)
+			app.routes().ServeHTTP(w, authorize(t, app, req, tt.userID))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+			if tt.expectedStatus != http.StatusCreated {
+				return
+			}
+
+			var res struct {
+				Data struct {
+					Calendar calendarFeed `json:"calendar"`
+				} `json:"data"`
+			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+			feed, err := url.Parse(res.Data.Calendar.URL)
+			assert.NoError(t, err)
+			assert.Equal(t, "/users/1/calendar.ics", feed.Path)
+			assert.NotEmpty(t, feed.Query().Get("token"))
+		})
+	}
+
+	t.Run("Rotating the token", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		var urls []string
+		for i := 0; i < 2; i++ {
+			mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1").
+				WithArgs(1).
+				WillReturnRows(mockRows(testUser(t)))
+			mock.ExpectExec("INSERT INTO calendar_feeds").
+				WithArgs(1, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/users/1/calendar/token", nil)
+			app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+			var res struct {
+				Data struct {
+					Calendar calendarFeed `json:"calendar"`
+				} `json:"data"`
+			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+			urls = append(urls, res.Data.Calendar.URL)
+		}
+		assert.NotEqual(t, urls[0], urls[1])
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestImportEvents(t *testing.T) {
+	start := time.Now().AddDate(1, 0, 0).UTC().Truncate(time.Second)
+	vevent := func(uid, summary, dtstart string) string {
+		return "BEGIN:VEVENT\r\n" +
+			"UID:" + uid + "\r\n" +
+			"SUMMARY:" + summary + "\r\n" +
+			"DESCRIPTION:Imported from another calendar\r\n" +
+			"DTSTART:" + dtstart + "\r\n" +
+			"END:VEVENT\r\n"
+	}
+	dtstart := start.Format("20060102T150405Z")
+	calendar := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Example//EN\r\n" +
+		vevent("new@example.com", "Team Offsite", dtstart) +
+		vevent("old@example.com", "Weekly Standup", dtstart) +
+		vevent("short@example.com", "Lunch", dtstart) +
+		vevent("new@example.com", "Team Offsite, moved", dtstart) +
+		vevent("bad@example.com", "Quarterly Review", "next tuesday") +
+		"END:VCALENDAR\r\n"
+
+	expectImport := func(mock sqlmock.Sqlmock) {
+		expectOwner(mock, 1, true)
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT uid, id FROM events WHERE user_id = \\$1 AND uid IN \\(\\$2,\\$3,\\$4\\)").
+			WithArgs(1, "new@example.com", "old@example.com", "new@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"uid", "id"}).AddRow("old@example.com", 7))
+		mock.ExpectQuery("INSERT INTO events \\((.+)\\) VALUES \\((.+)\\) RETURNING id").
+			WithArgs(1, "Team Offsite", "Imported from another calendar", start, start.Add(models.DefaultEventDuration), false, "UTC", "", "{}", "new@example.com", 0).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+		mock.ExpectCommit()
+	}
+
+	tests := []struct {
+		name    string
+		request func() *http.Request
+	}{
+		{
+			name: "Calendar as the body",
+			request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/events/import", strings.NewReader(calendar))
+				req.Header.Set("Content-Type", "text/calendar")
+				return req
+			},
+		},
+		{
+			name: "Calendar uploaded in a form",
+			request: func() *http.Request {
+				var body bytes.Buffer
+				form := multipart.NewWriter(&body)
+				file, _ := form.CreateFormFile("file", "team.ics")
+				file.Write([]byte(calendar))
+				form.Close()
+				req := httptest.NewRequest(http.MethodPost, "/events/import", &body)
+				req.Header.Set("Content-Type", form.FormDataContentType())
+				return req
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			expectImport(mock)
+
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, authorize(t, app, tt.request(), 1))
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			var res struct {
+				Data struct {
+					Import importReport `json:"import"`
+				} `json:"data"`
+			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+			report := res.Data.Import
+			assert.Equal(t, 1, report.Created)
+			assert.Equal(t, 2, report.Duplicates)
+			assert.Equal(t, 2, report.Invalid)
+			if assert.Len(t, report.Items, 5) {
+				assert.Equal(t, importItem{UID: "new@example.com", Name: "Team Offsite", Status: importCreated, ID: 8}, report.Items[0])
+				assert.Equal(t, importItem{UID: "old@example.com", Name: "Weekly Standup", Status: importDuplicate, ID: 7}, report.Items[1])
+				assert.Equal(t, importInvalid, report.Items[2].Status)
+				if assert.Len(t, report.Items[2].Errors, 1) {
+					assert.Equal(t, "name", report.Items[2].Errors[0].Field)
+				}
+				assert.Equal(t, importItem{UID: "new@example.com", Name: "Team Offsite, moved", Status: importDuplicate, ID: 8}, report.Items[3])
+				assert.Equal(t, importInvalid, report.Items[4].Status)
+				assert.Contains(t, report.Items[4].Error, "DTSTART")
+			}
+		})
+	}
+
+	t.Run("Recurring events and moved occurrences", func(t *testing.T) {
+		at := func(days int) string {
+			return start.AddDate(0, 0, days).Format("20060102T150405Z")
+		}
+		recurring := func(uid, summary, extra string) string {
+			return "BEGIN:VEVENT\r\n" +
+				"UID:" + uid + "\r\n" +
+				"SUMMARY:" + summary + "\r\n" +
+				"DESCRIPTION:Imported from another calendar\r\n" +
+				"DTSTART:" + dtstart + "\r\n" +
+				extra +
+				"END:VEVENT\r\n"
+		}
+		calendar := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Example//EN\r\n" +
+			// The moved occurrence comes before the event it belongs to
+			"BEGIN:VEVENT\r\nUID:weekly@example.com\r\nSUMMARY:Weekly Sync\r\n" +
+			"RECURRENCE-ID:" + at(14) + "\r\nDTSTART:" + at(15) + "\r\nEND:VEVENT\r\n" +
+			recurring("weekly@example.com", "Weekly Sync", "RRULE:FREQ=WEEKLY;COUNT=4\r\nEXDATE:"+at(7)+"\r\n") +
+			recurring("weekly@example.com", "Weekly Sync", "RECURRENCE-ID:"+at(7)+"\r\n") +
+			recurring("standup@example.com", "Weekly Standup", "RRULE:FREQ=WEEKLY\r\n") +
+			recurring("standup@example.com", "Weekly Standup", "RECURRENCE-ID:"+at(7)+"\r\n") +
+			recurring("lost@example.com", "Lost", "RECURRENCE-ID:"+at(7)+"\r\n") +
+			"END:VCALENDAR\r\n"
+
+		moved := models.OccurrenceOverride{
+			EventID:       8,
+			OriginalStart: start.AddDate(0, 0, 14),
+			StartDate:     start.AddDate(0, 0, 15),
+			EndDate:       start.AddDate(0, 0, 15).Add(models.DefaultEventDuration),
+		}
+		app, mock := newTestApp(t)
+		expectOwner(mock, 1, true)
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT uid, id FROM events WHERE user_id = \\$1 AND uid IN \\(\\$2,\\$3\\)").
+			WithArgs(1, "weekly@example.com", "standup@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"uid", "id"}).AddRow("standup@example.com", 7))
+		mock.ExpectQuery("INSERT INTO events \\((.+)\\) VALUES \\((.+)\\) RETURNING id").
+			WithArgs(1, "Weekly Sync", "Imported from another calendar", start, start.Add(models.DefaultEventDuration), false, "UTC",
+				"FREQ=WEEKLY;COUNT=4", models.TimeList{start.AddDate(0, 0, 7)}, "weekly@example.com", 0).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+		mock.ExpectQuery("INSERT INTO occurrence_overrides \\((.+)\\) VALUES (.+) ON CONFLICT").
+			WithArgs(moved.EventID, moved.OriginalStart, false, moved.StartDate, moved.EndDate).
+			WillReturnRows(mockRows(moved))
+		mock.ExpectCommit()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/events/import", strings.NewReader(calendar))
+		app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		var res struct {
+			Data struct {
+				Import importReport `json:"import"`
+			} `json:"data"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		report := res.Data.Import
+		assert.Equal(t, 1, report.Created)
+		assert.Equal(t, 1, report.Moved)
+		assert.Equal(t, 2, report.Duplicates)
+		assert.Equal(t, 2, report.Invalid)
+		if assert.Len(t, report.Items, 6) {
+			assert.Equal(t, importItem{UID: "weekly@example.com", Name: "Weekly Sync", Status: importMoved, ID: 8}, report.Items[0])
+			assert.Equal(t, importItem{UID: "weekly@example.com", Name: "Weekly Sync", Status: importCreated, ID: 8}, report.Items[1])
+			// An exception date isn't an occurrence to move
+			assert.Equal(t, importInvalid, report.Items[2].Status)
+			assert.Equal(t, errNotAnOccurrence.Error(), report.Items[2].Error)
+			assert.Equal(t, importItem{UID: "standup@example.com", Name: "Weekly Standup", Status: importDuplicate, ID: 7}, report.Items[3])
+			assert.Equal(t, importItem{UID: "standup@example.com", Name: "Weekly Standup", Status: importDuplicate, ID: 7}, report.Items[4])
+			assert.Equal(t, importInvalid, report.Items[5].Status)
+			assert.Equal(t, errNoRecurringEvent.Error(), report.Items[5].Error)
+		}
+	})
+
+	t.Run("Moved occurrence of a recurring event that duplicates another", func(t *testing.T) {
+		event := func(extra string) string {
+			return "BEGIN:VEVENT\r\n" +
+				"UID:offsite@example.com\r\n" +
+				"SUMMARY:Team Offsite\r\n" +
+				"DESCRIPTION:Imported from another calendar\r\n" +
+				"DTSTART:" + dtstart + "\r\n" +
+				extra +
+				"END:VEVENT\r\n"
+		}
+		// A plain event comes before the recurring one with its UID, so only
+		// the plain one is created, and the occurrence isn't moved on it
+		calendar := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Example//EN\r\n" +
+			event("") +
+			event("RRULE:FREQ=WEEKLY;COUNT=4\r\n") +
+			event("RECURRENCE-ID:"+start.AddDate(0, 0, 7).Format("20060102T150405Z")+"\r\n") +
+			"END:VCALENDAR\r\n"
+
+		app, mock := newTestApp(t)
+		expectOwner(mock, 1, true)
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT uid, id FROM events WHERE user_id = \\$1").
+			WillReturnRows(sqlmock.NewRows([]string{"uid", "id"}))
+		mock.ExpectQuery("INSERT INTO events \\((.+)\\) VALUES \\((.+)\\) RETURNING id").
+			WithArgs(1, "Team Offsite", "Imported from another calendar", start, start.Add(models.DefaultEventDuration), false, "UTC",
+				"", models.TimeList(nil), "offsite@example.com", 0).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+		mock.ExpectCommit()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/events/import", strings.NewReader(calendar))
+		app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		var res struct {
+			Data struct {
+				Import importReport `json:"import"`
+			} `json:"data"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		report := res.Data.Import
+		assert.Equal(t, 1, report.Created)
+		assert.Equal(t, 0, report.Moved)
+		assert.Equal(t, 2, report.Duplicates)
+		if assert.Len(t, report.Items, 3) {
+			assert.Equal(t, importItem{UID: "offsite@example.com", Name: "Team Offsite", Status: importCreated, ID: 9}, report.Items[0])
+			assert.Equal(t, importItem{UID: "offsite@example.com", Name: "Team Offsite", Status: importDuplicate, ID: 9}, report.Items[1])
+			assert.Equal(t, importItem{UID: "offsite@example.com", Name: "Team Offsite", Status: importDuplicate, ID: 9}, report.Items[2])
+		}
+	})
+
+	t.Run("Not a calendar", func(t *testing.T) {
+		app, mock := newTestApp(t)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/events/import", strings.NewReader(`{"name": "Team Offsite"}`))
+		app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Failed insert rolls the import back", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		expectOwner(mock, 1, true)
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT uid, id FROM events").
+			WillReturnRows(sqlmock.NewRows([]string{"uid", "id"}))
+		mock.ExpectQuery("INSERT INTO events").
+			WillReturnError(errors.New("connection reset"))
+		mock.ExpectRollback()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/events/import", strings.NewReader(calendar))
+		app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
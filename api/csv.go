@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"events-app/data/models"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	timeListType = reflect.TypeOf(models.TimeList{})
+)
+
+// csvHeader names a model's CSV columns after its JSON fields, in the order
+// of its db columns. Write-only fields are left out.
+func csvHeader(m models.Model) []string {
+	dbToJSON := make(map[string]string)
+	for jsonTag, dbTag := range models.MapJsonTagsToDB(m) {
+		dbToJSON[dbTag] = jsonTag
+	}
+
+	typ := reflect.TypeOf(m)
+	var header []string
+	for i, column := range models.GetColumnNames(m, false) {
+		if typ.Field(i).Tag.Get("writeOnly") == "true" {
+			continue
+		}
+		header = append(header, dbToJSON[column])
+	}
+	return header
+}
+
+// csvRecord writes a model's fields as the cells of a CSV row, in the order
+// of csvHeader. Times are written in RFC 3339, and lists of times as times
+// separated by commas; a zero time is left blank. Text a spreadsheet would
+// take for a formula is escaped; see escapeCSVText.
+func csvRecord(m models.Model) []string {
+	val := reflect.ValueOf(m)
+	typ := val.Type()
+	var record []string
+	for i := 0; i < typ.NumField(); i++ {
+		if typ.Field(i).Tag.Get("writeOnly") == "true" {
+			continue
+		}
+		record = append(record, csvCell(val.Field(i)))
+	}
+	return record
+}
+
+func csvCell(v reflect.Value) string {
+	switch v.Type() {
+	case timeType:
+		if t := v.Interface().(time.Time); !t.IsZero() {
+			return t.Format(time.RFC3339)
+		}
+		return ""
+	case timeListType:
+		times := v.Interface().(models.TimeList)
+		cells := make([]string, len(times))
+		for i, t := range times {
+			cells[i] = t.Format(time.RFC3339)
+		}
+		return strings.Join(cells, ",")
+	}
+	if v.Kind() == reflect.String {
+		return escapeCSVText(v.String())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// csvFormulaPrefixes start the cells a spreadsheet evaluates as formulas. A
+// quote is escaped too, so one that was already there survives the round
+// trip through unescapeCSVText.
+const csvFormulaPrefixes = "=+-@\t\r'"
+
+// escapeCSVText prefixes text starting like a formula with a quote, which
+// spreadsheets take to mean the cell is plain text and don't show.
+func escapeCSVText(s string) string {
+	if s != "" && strings.ContainsRune(csvFormulaPrefixes, rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// unescapeCSVText undoes escapeCSVText.
+func unescapeCSVText(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune(csvFormulaPrefixes, rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
+
+// csvReader reads CSV rows into models of one type, matching the columns of
+// the header to their JSON fields. Read-only columns, e.g. the IDs in a file
+// written by the export, are ignored.
+type csvReader struct {
+	typ reflect.Type
+	// fields holds the index of the field each column is read into, or -1 if
+	// it is ignored
+	fields []int
+	names  []string
+}
+
+// newCSVReader returns a reader for rows with the given header. It returns an
+// error if a column isn't one of the model's fields, or is repeated.
+func newCSVReader(m models.Model, header []string) (*csvReader, error) {
+	typ := reflect.TypeOf(m)
+	byName := make(map[string]int, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		byName[typ.Field(i).Tag.Get("json")] = i
+	}
+
+	r := &csvReader{typ: typ, fields: make([]int, len(header)), names: make([]string, len(header))}
+	seen := make(map[string]bool, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		field, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column: %s", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("repeated column: %s", name)
+		}
+		seen[name] = true
+
+		if typ.Field(field).Tag.Get("readOnly") == "true" {
+			field = -1
+		}
+		r.fields[i] = field
+		r.names[i] = name
+	}
+	return r, nil
+}
+
+// read returns a new model with the fields of the row set; blank cells leave
+// their field at its zero value. Cells that can't be read as their field are
+// left out, and reported as ValidationErrors with the rule "type".
+func (r *csvReader) read(record []string) (models.Model, error) {
+	val := reflect.New(r.typ).Elem()
+	var errs models.ValidationErrors
+	for i, cell := range record {
+		if i >= len(r.fields) || r.fields[i] < 0 || strings.TrimSpace(cell) == "" {
+			continue
+		}
+		if err := setCSVField(val.Field(r.fields[i]), cell); err != nil {
+			errs = append(errs, models.FieldError{Field: r.names[i], Rule: "type", Message: fmt.Sprintf("%s %s", r.names[i], err)})
+		}
+	}
+	if len(errs) > 0 {
+		return val.Interface().(models.Model), errs
+	}
+	return val.Interface().(models.Model), nil
+}
+
+func setCSVField(field reflect.Value, cell string) error {
+	switch field.Type() {
+	case timeType:
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(cell))
+		if err != nil {
+			return errors.New("must be an RFC 3339 time, e.g. 2024-06-01T18:00:00+02:00")
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	case timeListType:
+		var times models.TimeList
+		for _, s := range strings.Split(cell, ",") {
+			t, err := time.Parse(time.RFC3339, strings.TrimSpace(s))
+			if err != nil {
+				return errors.New("must be RFC 3339 times separated by commas")
+			}
+			times = append(times, t)
+		}
+		field.Set(reflect.ValueOf(times))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(unescapeCSVText(cell))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(cell))
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(cell), 10, 64)
+		if err != nil {
+			return errors.New("must be a whole number")
+		}
+		field.SetInt(n)
+	default:
+		return errors.New("can't be imported")
+	}
+	return nil
+}
+
+// parseCSVEvents reads the rows of a CSV file as events. Its first row is the
+// header, naming each column after an event's JSON field; see csvReader. The
+// events have no owner, and haven't been validated.
+func parseCSVEvents(r io.Reader) ([]importEntry, error) {
+	cr := csv.NewReader(r)
+	// Rows with too few or too many cells are reported along with the rest
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("invalid CSV file: it is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV file: %w", err)
+	}
+	reader, err := newCSVReader(models.Event{}, header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV file: %w", err)
+	}
+
+	var entries []importEntry
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV file: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+
+		m, err := reader.read(record)
+		if len(record) != len(header) {
+			err = fmt.Errorf("expected %d cells, got %d", len(header), len(record))
+		}
+		entries = append(entries, importEntry{Row: line, Event: m.(models.Event), Err: err})
+	}
+}
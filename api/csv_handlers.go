@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/csv"
+	"events-app/data/models"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// csvExportBatch is how many events an export reads from the db at a time.
+const csvExportBatch = 500
+
+// csvExportBatchTimeout is how long an export may take to read and write out
+// each batch. It replaces the server's write timeout, which would otherwise
+// cut off large exports partway.
+const csvExportBatchTimeout = 30 * time.Second
+
+// exportEvents handles GET /events/export.csv, writing the events GET /events
+// would list as a CSV file, with a column for each of their JSON fields. It
+// takes the same filters, but without a limit exports every event that
+// matches. Events are read a batch at a time and written out as they arrive;
+// each batch picks up after a cursor to the last, so deep batches cost no
+// more than the first, and gets csvExportBatchTimeout to be written.
+func (app *application) exportEvents(w http.ResponseWriter, r *http.Request) {
+	params, err := queryParamsFromURL(r.URL.Query())
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	loc, ok := app.readLocation(w, r)
+	if !ok {
+		return
+	}
+	if loc != nil {
+		params["tz"] = loc.String()
+	}
+	limit, err := countParam(params, "limit", -1)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := countParam(params, "offset", 0); err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	cw := csv.NewWriter(w)
+	started := false
+	start := func() {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="events.csv"`)
+		w.WriteHeader(http.StatusOK)
+		cw.Write(csvHeader(models.Event{}))
+		started = true
+	}
+
+	for exported := 0; limit < 0 || exported < limit; {
+		batch := csvExportBatch
+		if limit >= 0 && limit-exported < batch {
+			batch = limit - exported
+		}
+		params["limit"] = strconv.Itoa(batch)
+		// Writers that can't set a deadline have no timeout to extend
+		rc.SetWriteDeadline(time.Now().Add(csvExportBatchTimeout))
+
+		events, err := app.Repo.QueryEventsContext(r.Context(), params)
+		if err != nil && !started {
+			app.sendRepoError(w, err)
+			return
+		}
+		if err != nil {
+			// The response is under way, so all that can be done is to cut
+			// the file short
+			log.Printf("error exporting events: %v", err)
+			return
+		}
+		if !started {
+			start()
+		}
+
+		for _, e := range events {
+			cw.Write(csvRecord(eventIn(e, loc)))
+		}
+		cw.Flush()
+		if cw.Error() != nil {
+			// The client has gone away
+			return
+		}
+		rc.Flush()
+
+		exported += len(events)
+		if len(events) < batch {
+			break
+		}
+		// Only the first batch is read from the offset
+		delete(params, "offset")
+		if params["cursor"], err = app.Repo.NextCursor(events[len(events)-1], params); err != nil {
+			log.Printf("error exporting events: %v", err)
+			return
+		}
+	}
+
+	if !started {
+		start()
+		cw.Flush()
+	}
+}
+
+// countParam reads the limit or offset query parameter, returning def if it
+// isn't given.
+func countParam(params map[string]string, key string, def int) (int, error) {
+	value, ok := params[key]
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a whole number", key)
+	}
+	return n, nil
+}
+
+// importEventsCSV handles POST /events/import.csv, creating events for the
+// caller from the CSV file sent as the body, or as the file field of a
+// multipart form. Its header names each column after an event's JSON field,
+// as in the file GET /events/export.csv writes. The report gives the line
+// each event was read from. See importEntries.
+func (app *application) importEventsCSV(w http.ResponseWriter, r *http.Request) {
+	body, err := importFile(w, r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	defer body.Close()
+
+	entries, err := parseCSVEvents(body)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	app.importAndReport(w, r, entries)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"events-app/data/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportEvents(t *testing.T) {
+	batch := make([]models.Model, csvExportBatch)
+	for i := range batch {
+		batch[i] = testEvent()
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		setup          func(mock sqlmock.Sqlmock)
+		expectedStatus int
+		expectedRows   int
+	}{
+		{
+			name: "Every event, a batch at a time",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM events ORDER BY id ASC LIMIT \\$1 OFFSET \\$2").
+					WithArgs(csvExportBatch, 0).
+					WillReturnRows(mockRows(batch...))
+				// The next batch picks up after the last event of the first
+				mock.ExpectQuery("SELECT (.+) FROM events WHERE id > \\$1 ORDER BY id ASC LIMIT \\$2 OFFSET \\$3").
+					WithArgs(testEvent().ID, csvExportBatch, 0).
+					WillReturnRows(mockRows(testEvent()))
+			},
+			expectedStatus: http.StatusOK,
+			expectedRows:   csvExportBatch + 1,
+		},
+		{
+			name:  "Filters, limit and offset",
+			query: "?name_contains=Test&limit=2&offset=4",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM events WHERE name LIKE \\$1 ORDER BY id ASC LIMIT \\$2 OFFSET \\$3").
+					WithArgs("%Test%", 2, 4).
+					WillReturnRows(mockRows(testEvent(), testEvent()))
+			},
+			expectedStatus: http.StatusOK,
+			expectedRows:   2,
+		},
+		{
+			name: "No events",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM events").
+					WillReturnRows(sqlmock.NewRows(models.GetColumnNames(models.Event{}, false)))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Unknown filter",
+			query:          "?colour=red",
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid limit",
+			query:          "?limit=all",
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			tt.setup(mock)
+
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events/export.csv"+tt.query, nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+			records, err := csv.NewReader(w.Body).ReadAll()
+			assert.NoError(t, err)
+			if assert.Len(t, records, tt.expectedRows+1) {
+				assert.Equal(t, csvHeader(models.Event{}), records[0])
+			}
+			if tt.expectedRows > 0 {
+				assert.Equal(t, csvRecord(testEvent()), records[1])
+			}
+		})
+	}
+
+	t.Run("Times in the caller's zone", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		mock.ExpectQuery("SELECT (.+) FROM events").
+			WillReturnRows(mockRows(testEvent()))
+
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events/export.csv?tz=Asia/Tokyo", nil))
+
+		tokyo, _ := time.LoadLocation("Asia/Tokyo")
+		assert.Contains(t, w.Body.String(), testEvent().StartDate.In(tokyo).Format(time.RFC3339))
+	})
+
+	t.Run("Longer than the server's write timeout", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		mock.ExpectQuery("SELECT (.+) FROM events").
+			WillReturnRows(mockRows(batch...))
+		mock.ExpectQuery("SELECT (.+) FROM events").
+			WillDelayFor(300 * time.Millisecond).
+			WillReturnRows(mockRows(testEvent()))
+
+		srv := httptest.NewUnstartedServer(app.routes())
+		srv.Config.WriteTimeout = 100 * time.Millisecond
+		srv.Start()
+		defer srv.Close()
+
+		resp, err := srv.Client().Get(srv.URL + "/events/export.csv")
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer resp.Body.Close()
+
+		// Every batch is written, rather than the file being cut off after
+		// the first
+		records, err := csv.NewReader(resp.Body).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, records, csvExportBatch+2)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestImportEventsCSV(t *testing.T) {
+	start := time.Now().AddDate(1, 0, 0).UTC().Truncate(time.Second)
+	file := strings.Join([]string{
+		"name,description,startDate,timezone,maxAttendees",
+		"Team Offsite,Two days in the mountains," + start.Format(time.RFC3339) + ",Europe/Berlin,20",
+		"Lunch,Sandwiches in the park," + start.Format(time.RFC3339) + ",UTC,0",
+		"Quarterly Review,Numbers for the quarter,next tuesday,UTC,0",
+		// Imported events are new, so they can't start in the past
+		"Year in Review,Looking back at the year," + start.AddDate(-2, 0, 0).Format(time.RFC3339) + ",UTC,0",
+	}, "\n")
+
+	type response struct {
+		Status string `json:"status"`
+		Data   struct {
+			Import importReport `json:"import"`
+		} `json:"data"`
+	}
+
+	t.Run("Best effort", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		expectOwner(mock, 1, true)
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO events \\((.+)\\) VALUES \\((.+)\\) RETURNING id").
+			WithArgs(1, "Team Offsite", "Two days in the mountains", start, start.Add(models.DefaultEventDuration), false, "Europe/Berlin", "", "{}", "", 20).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+		mock.ExpectCommit()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/events/import.csv?mode=bestEffort", strings.NewReader(file))
+		req.Header.Set("Content-Type", "text/csv")
+		app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		var res response
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		report := res.Data.Import
+		assert.Equal(t, 1, report.Created)
+		assert.Equal(t, 3, report.Invalid)
+		if assert.Len(t, report.Items, 4) {
+			assert.Equal(t, importItem{Row: 2, Name: "Team Offsite", Status: importCreated, ID: 8}, report.Items[0])
+			assert.Equal(t, 3, report.Items[1].Row)
+			assert.Equal(t, importInvalid, report.Items[1].Status)
+			if assert.Len(t, report.Items[1].Errors, 1) {
+				assert.Equal(t, "name", report.Items[1].Errors[0].Field)
+			}
+			assert.Equal(t, 4, report.Items[2].Row)
+			if assert.Len(t, report.Items[2].Errors, 1) {
+				assert.Equal(t, "startDate", report.Items[2].Errors[0].Field)
+			}
+			if assert.Len(t, report.Items[3].Errors, 1) {
+				assert.Equal(t, "futuredate", report.Items[3].Errors[0].Rule)
+			}
+		}
+	})
+
+	t.Run("Atomic import of an invalid file", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		expectOwner(mock, 1, true)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/events/import.csv?mode=atomic", strings.NewReader(file))
+		app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		var res response
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		assert.Equal(t, "fail", res.Status)
+		report := res.Data.Import
+		assert.Equal(t, 0, report.Created)
+		assert.Equal(t, 3, report.Invalid)
+		if assert.Len(t, report.Items, 4) {
+			assert.Equal(t, importSkipped, report.Items[0].Status)
+			assert.Equal(t, importInvalid, report.Items[1].Status)
+			assert.Equal(t, importInvalid, report.Items[2].Status)
+			assert.Equal(t, importInvalid, report.Items[3].Status)
+		}
+	})
+
+	t.Run("Atomic import of a valid file", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		expectOwner(mock, 1, true)
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO events").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+		mock.ExpectCommit()
+
+		valid := strings.Join(strings.Split(file, "\n")[:2], "\n")
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/events/import.csv?mode=atomic", strings.NewReader(valid))
+		app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Owner can't be looked up", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1").
+			WillReturnError(errors.New("connection reset"))
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/events/import.csv", strings.NewReader(file))
+		app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown column", func(t *testing.T) {
+		app, mock := newTestApp(t)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/events/import.csv", strings.NewReader("name,colour\nTeam Offsite,red\n"))
+		app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "unknown column: colour")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unknown mode", func(t *testing.T) {
+		app, mock := newTestApp(t)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/events/import.csv?mode=sometimes", strings.NewReader(file))
+		app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Requires a login", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/events/import.csv", strings.NewReader(file)))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
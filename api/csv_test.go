@@ -0,0 +1,125 @@
+package main
+
+import (
+	"events-app/data/models"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSVHeader(t *testing.T) {
+	assert.Equal(t,
+		[]string{"id", "userId", "name", "description", "startDate", "endDate", "allDay", "timezone", "rrule", "exdates", "uid", "createdAt", "maxAttendees", "version"},
+		csvHeader(models.Event{}))
+	// Passwords are write-only
+	assert.NotContains(t, csvHeader(models.User{}), "password")
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	e := weeklyEvent()
+	e.ExDates = models.TimeList{e.StartDate.AddDate(0, 0, 7), e.StartDate.AddDate(0, 0, 14)}
+	e.UID = "weekly@example.com"
+
+	reader, err := newCSVReader(models.Event{}, csvHeader(models.Event{}))
+	assert.NoError(t, err)
+	m, err := reader.read(csvRecord(e))
+	assert.NoError(t, err)
+
+	// Read-only fields aren't imported
+	e.ID, e.CreatedAt, e.Version = 0, time.Time{}, 0
+	assert.Equal(t, e, m)
+}
+
+func TestCSVFormulas(t *testing.T) {
+	e := testEvent()
+	e.Name = "=HYPERLINK(\"http://example.com\")"
+	e.Description = "+1 for the offsite"
+	e.UID = "'quoted"
+	e.Timezone = "@home"
+
+	record := csvRecord(e)
+	header := csvHeader(models.Event{})
+	cell := func(name string) string {
+		return record[slices.Index(header, name)]
+	}
+	assert.Equal(t, "'=HYPERLINK(\"http://example.com\")", cell("name"))
+	assert.Equal(t, "'+1 for the offsite", cell("description"))
+	assert.Equal(t, "''quoted", cell("uid"))
+	assert.Equal(t, "'@home", cell("timezone"))
+	// Numbers aren't text, so they're never taken for formulas
+	assert.Equal(t, "1", cell("id"))
+
+	for _, s := range []string{"-", "\tindented", "\rreturn", "'", "''", "plain", ""} {
+		assert.Equal(t, s, unescapeCSVText(escapeCSVText(s)), "%q", s)
+	}
+
+	// The text comes back as it was when the file is imported
+	reader, err := newCSVReader(models.Event{}, header)
+	assert.NoError(t, err)
+	m, err := reader.read(record)
+	assert.NoError(t, err)
+	assert.Equal(t, e.Name, m.(models.Event).Name)
+	assert.Equal(t, e.Description, m.(models.Event).Description)
+	assert.Equal(t, e.UID, m.(models.Event).UID)
+	assert.Equal(t, e.Timezone, m.(models.Event).Timezone)
+}
+
+func TestCSVReader(t *testing.T) {
+	t.Run("Unknown column", func(t *testing.T) {
+		_, err := newCSVReader(models.Event{}, []string{"name", "colour"})
+		assert.ErrorContains(t, err, "unknown column: colour")
+	})
+
+	t.Run("Repeated column", func(t *testing.T) {
+		_, err := newCSVReader(models.Event{}, []string{"name", " name"})
+		assert.ErrorContains(t, err, "repeated column: name")
+	})
+
+	t.Run("Cells of the wrong type", func(t *testing.T) {
+		reader, err := newCSVReader(models.Event{}, []string{"name", "startDate", "allDay", "maxAttendees"})
+		assert.NoError(t, err)
+		m, err := reader.read([]string{"Team Offsite", "next tuesday", "yes", "50"})
+
+		var errs models.ValidationErrors
+		if assert.ErrorAs(t, err, &errs) && assert.Len(t, errs, 2) {
+			assert.Equal(t, "startDate", errs[0].Field)
+			assert.Equal(t, "type", errs[0].Rule)
+			assert.Equal(t, "allDay", errs[1].Field)
+		}
+		// The rest of the row is still read, so it can be reported
+		assert.Equal(t, "Team Offsite", m.(models.Event).Name)
+		assert.Equal(t, 50, m.(models.Event).MaxAttendees)
+	})
+}
+
+func TestParseCSVEvents(t *testing.T) {
+	entries, err := parseCSVEvents(strings.NewReader(strings.Join([]string{
+		"name,description,startDate",
+		`"Team Offsite, day one",In the mountains,2030-06-01T09:00:00+02:00`,
+		"Team Offsite,In the mountains",
+		"",
+		"Team Offsite,In the mountains,2030-06-02T09:00:00Z",
+	}, "\n")))
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 3) {
+		assert.NoError(t, entries[0].Err)
+		assert.Equal(t, 2, entries[0].Row)
+		assert.Equal(t, "Team Offsite, day one", entries[0].Event.Name)
+		assert.True(t, entries[0].Event.StartDate.Equal(time.Date(2030, 6, 1, 7, 0, 0, 0, time.UTC)))
+
+		assert.Equal(t, 3, entries[1].Row)
+		assert.ErrorContains(t, entries[1].Err, "expected 3 cells, got 2")
+
+		// Blank lines are skipped, but still counted
+		assert.Equal(t, 5, entries[2].Row)
+		assert.NoError(t, entries[2].Err)
+	}
+
+	_, err = parseCSVEvents(strings.NewReader(""))
+	assert.Error(t, err)
+	_, err = parseCSVEvents(strings.NewReader("name,\"description\n"))
+	assert.Error(t, err)
+}
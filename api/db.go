@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"events-app/data/repository"
 	"fmt"
 	"log"
 
@@ -18,6 +19,29 @@ func (app *application) ConnectToDB() (*sql.DB, error) {
 	return db, nil
 }
 
+// openRepo connects to the database, brings its schema up to date and sets
+// app.Repo up on it. The caller should close the returned connection when
+// done.
+func (app *application) openRepo() (*sql.DB, error) {
+	db, err := app.ConnectToDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to db: %v", err)
+	}
+
+	app.Repo = &repository.SqlRepo{
+		DB:           db,
+		QueryTimeout: app.QueryTimeout,
+		MaxTxRetries: app.TxRetries,
+		CursorKey:    []byte(app.JWTSecret),
+	}
+
+	if err = app.Repo.RunMigrations("db"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
 func openDB(dsn string) (*sql.DB, error) {
 	db, err := sql.Open("pgx", dsn)
 	if err != nil {
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errPreconditionFailed = errors.New("the resource has been modified; fetch it again and retry")
+
+// etag returns the entity tag for the given version of a resource.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatch reports whether the request's If-Match header, if it has one,
+// matches the given entity tag. Weak tags never match, as If-Match requires a
+// strong comparison.
+func ifMatch(r *http.Request, tag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch responds with a 412 if the request's If-Match precondition
+// doesn't hold for the given entity tag. Handlers must stop if it returns
+// false.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, tag string) bool {
+	if !ifMatch(r, tag) {
+		w.Header().Set("ETag", tag)
+		app.SendErrorJSON(w, http.StatusPreconditionFailed, errPreconditionFailed)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"events-app/data/models"
+	"events-app/data/repository"
+	"net/http"
+	"strings"
+)
+
+// listEvents handles GET /events. Filters on times without an offset, e.g.
+// startDate_gte=2024-06-01, are read in the zone the caller asked for. Given a
+// window to look in, e.g. during=2024-06-01,2024-06-30, it lists occurrences,
+// so a recurring event appears once for each time it takes place; those are
+// paged through by offset only.
+func (app *application) listEvents(w http.ResponseWriter, r *http.Request) {
+	params, err := queryParamsFromURL(r.URL.Query())
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	loc, ok := app.readLocation(w, r)
+	if !ok {
+		return
+	}
+	if loc != nil {
+		params["tz"] = loc.String()
+	}
+
+	page, err := readPage(params)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, ok := params["during"]; ok {
+		app.sendOccurrences(w, r, page, params, loc, "events")
+		return
+	}
+	events, err := app.Repo.QueryEventsContext(r.Context(), params)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+	if err := app.finishPage(r.Context(), &page, models.Event{}, params, events); err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+	for i := range events {
+		events[i] = eventIn(events[i], loc)
+	}
+
+	app.sendPage(w, r, page, events, "events")
+}
+
+func (app *application) createEvent(w http.ResponseWriter, r *http.Request) {
+	loc, ok := app.readLocation(w, r)
+	if !ok {
+		return
+	}
+
+	var event models.Event
+	if err := app.ReadJSON(w, r, &event, false); err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	// The owner is whoever is logged in, never what the client claims, so the
+	// event is only validated once it's set
+	a, _ := actorFromContext(r.Context())
+	event.UserID = a.ID
+	if err := models.ValidateModelContext(validationContext(r.Context(), app.Repo), &event); err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	id, err := app.Repo.CreateContext(r.Context(), event)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	created, err := app.Repo.GetEventByIDContext(r.Context(), id)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(created.Version))
+	app.SendSuccessJSON(w, http.StatusCreated, eventIn(created, loc), "event")
+}
+
+// eventDetail is the representation of a single event. WaitlistPosition is
+// only set when the caller is on the event's waitlist.
+type eventDetail struct {
+	models.Event
+	WaitlistLength   int  `json:"waitlistLength"`
+	WaitlistPosition *int `json:"waitlistPosition,omitempty"`
+}
+
+// getEvent handles GET /events/{id}, and GET /events/{id}.ics since a
+// wildcard can't be followed by a suffix in a route.
+func (app *application) getEvent(w http.ResponseWriter, r *http.Request) {
+	if id, ok := strings.CutSuffix(r.PathValue("id"), ".ics"); ok {
+		r.SetPathValue("id", id)
+		app.exportEvent(w, r)
+		return
+	}
+
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	loc, ok := app.readLocation(w, r)
+	if !ok {
+		return
+	}
+
+	event, err := app.Repo.GetEventByIDContext(r.Context(), id)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	// Anonymous callers get a user ID of 0, which never matches anyone
+	a, _ := actorFromContext(r.Context())
+	length, position, err := app.Repo.WaitlistStatusContext(r.Context(), id, a.ID)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	detail := eventDetail{Event: eventIn(event, loc), WaitlistLength: length}
+	if position > 0 {
+		detail.WaitlistPosition = &position
+	}
+
+	w.Header().Set("ETag", etag(event.Version))
+	app.SendSuccessJSON(w, http.StatusOK, detail, "event")
+}
+
+// replaceEvent handles PUT requests; the payload must describe the whole event.
+func (app *application) replaceEvent(w http.ResponseWriter, r *http.Request) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	existing, err := app.Repo.GetEventByIDContext(r.Context(), id)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	if !app.authorize(w, r, canModifyEvent(existing)) {
+		return
+	}
+	if !app.checkIfMatch(w, r, etag(existing.Version)) {
+		return
+	}
+	if _, ok := app.readLocation(w, r); !ok {
+		return
+	}
+
+	var event models.Event
+	if err := app.ReadJSON(w, r, &event, false); err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	event.ID = existing.ID
+	event.UserID = existing.UserID
+	event.Version = existing.Version
+	// An event that has already started can be edited, but not moved into
+	// the past
+	ctx := models.WithStoredStart(validationContext(r.Context(), app.Repo), existing.StartDate)
+	if err := models.ValidateModelContext(ctx, &event); err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	app.saveEvent(w, r, existing, event, nil)
+}
+
+// patchEvent handles PATCH requests; only the fields the patch touches are
+// written. See ReadPatch for the accepted formats.
+func (app *application) patchEvent(w http.ResponseWriter, r *http.Request) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	event, err := app.Repo.GetEventByIDContext(r.Context(), id)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	if !app.authorize(w, r, canModifyEvent(event)) {
+		return
+	}
+	if !app.checkIfMatch(w, r, etag(event.Version)) {
+		return
+	}
+	if _, ok := app.readLocation(w, r); !ok {
+		return
+	}
+
+	existing := event
+	// The patched fields are validated as for PUT
+	ctx := models.WithStoredStart(validationContext(r.Context(), app.Repo), existing.StartDate)
+	fields, err := app.ReadPatch(w, r.WithContext(ctx), &event)
+	if err != nil {
+		app.sendPatchError(w, err)
+		return
+	}
+	event.ID = id
+	event.UserID = existing.UserID
+	event.Version = existing.Version
+
+	app.saveEvent(w, r, existing, event, fields)
+}
+
+// saveEvent persists an update to the existing event and responds with its
+// new state. Only the given fields are written, or all of them if fields is
+// nil. The update only goes through if the event is still at the version it
+// was read at. If its capacity changes, any seats that frees up go to the
+// waitlist in the same transaction.
+func (app *application) saveEvent(w http.ResponseWriter, r *http.Request, existing, event models.Event, fields []string) {
+	write := func(repo repository.DBRepo) error {
+		if fields == nil {
+			return repo.UpdateContext(r.Context(), event)
+		}
+		return repo.UpdateColumnsContext(r.Context(), event, fields)
+	}
+
+	var err error
+	if event.MaxAttendees == existing.MaxAttendees {
+		err = write(app.Repo)
+	} else {
+		err = app.Repo.WithTx(r.Context(), func(tx repository.DBRepo) error {
+			if err := write(tx); err != nil {
+				return err
+			}
+			return tx.PromoteWaitlistContext(r.Context(), event.ID)
+		})
+	}
+	if err != nil {
+		// The event changed between reading it and writing it back, so the
+		// client's precondition no longer holds either
+		if errors.Is(err, repository.ErrConflict) && r.Header.Get("If-Match") != "" {
+			app.SendErrorJSON(w, http.StatusPreconditionFailed, errPreconditionFailed)
+			return
+		}
+		app.sendRepoError(w, err)
+		return
+	}
+
+	updated, err := app.Repo.GetEventByIDContext(r.Context(), event.ID)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	// The caller's time zone was checked before anything was written
+	loc, _ := requestLocation(r)
+
+	w.Header().Set("ETag", etag(updated.Version))
+	app.SendSuccessJSON(w, http.StatusOK, eventIn(updated, loc), "event")
+}
+
+func (app *application) deleteEvent(w http.ResponseWriter, r *http.Request) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	event, err := app.Repo.GetEventByIDContext(r.Context(), id)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	if !app.authorize(w, r, canModifyEvent(event)) {
+		return
+	}
+	if !app.checkIfMatch(w, r, etag(event.Version)) {
+		return
+	}
+
+	if err := app.Repo.DeleteContext(r.Context(), event); err != nil {
+		// As in saveEvent, the event changed after the precondition was checked
+		if errors.Is(err, repository.ErrConflict) && r.Header.Get("If-Match") != "" {
+			app.SendErrorJSON(w, http.StatusPreconditionFailed, errPreconditionFailed)
+			return
+		}
+		app.sendRepoError(w, err)
+		return
+	}
+
+	app.SendSuccessJSON(w, http.StatusOK, nil)
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"events-app/data/models"
+	"events-app/data/repository"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/stretchr/testify/assert"
+)
+
+func testEvent() models.Event {
+	start := time.Now().Add(time.Hour * 24).UTC().Truncate(time.Second)
+	return models.Event{
+		ID:           1,
+		UserID:       1,
+		Name:         "Test Event",
+		Description:  "At the manor hotel",
+		StartDate:    start,
+		EndDate:      start.Add(time.Hour * 2),
+		Timezone:     "UTC",
+		CreatedAt:    time.Now().UTC().Truncate(time.Second),
+		MaxAttendees: 50,
+		Version:      3,
+	}
+}
+
+func TestGetEvent(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		setup          func(mock sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			name: "Existing event",
+			path: "/events/1",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+					WithArgs(1).
+					WillReturnRows(mockRows(testEvent()))
+				expectWaitlistStatus(mock, 0, 3, 0)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Missing event",
+			path: "/events/2",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+					WithArgs(2).
+					WillReturnError(sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Invalid id",
+			path:           "/events/abc",
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			tt.setup(mock)
+
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			if tt.expectedStatus == http.StatusOK {
+				var res struct {
+					Data struct {
+						Event models.Event `json:"event"`
+					} `json:"data"`
+				}
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+				assert.Equal(t, "Test Event", res.Data.Event.Name)
+			}
+		})
+	}
+}
+
+func expectWaitlistStatus(mock sqlmock.Sqlmock, userID int64, length, position int) {
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\), COUNT\\(\\*\\) FILTER (.+) FROM attendees").
+		WithArgs(1, userID, models.AttendeeWaitlisted).
+		WillReturnRows(sqlmock.NewRows([]string{"length", "position"}).AddRow(length, position))
+}
+
+func TestGetEventWaitlist(t *testing.T) {
+	tests := []struct {
+		name             string
+		userID           int64
+		position         int
+		expectedPosition *int
+	}{
+		{"Anonymous", 0, 0, nil},
+		{"Not waitlisted", 2, 0, nil},
+		{"Waitlisted", 2, 2, func() *int { p := 2; return &p }()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+				WithArgs(1).
+				WillReturnRows(mockRows(testEvent()))
+			expectWaitlistStatus(mock, tt.userID, 3, tt.position)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/events/1", nil)
+			if tt.userID != 0 {
+				req = authorize(t, app, req, tt.userID)
+			}
+			app.routes().ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			var res struct {
+				Data struct {
+					Event eventDetail `json:"event"`
+				} `json:"data"`
+			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+			assert.Equal(t, 3, res.Data.Event.WaitlistLength)
+			assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+			assert.Equal(t, tt.expectedPosition, res.Data.Event.WaitlistPosition)
+		})
+	}
+
+	t.Run("Invalid token", func(t *testing.T) {
+		app, mock := newTestApp(t)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/events/1", nil)
+		req.Header.Set("Authorization", "Bearer not-a-jwt")
+		app.routes().ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCreateEvent(t *testing.T) {
+	t.Run("Valid event", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		e := testEvent()
+
+		expectOwner(mock, e.UserID, true)
+		mock.ExpectPrepare("INSERT INTO events").
+			ExpectQuery().
+			WithArgs(e.UserID, e.Name, e.Description, e.StartDate, e.StartDate.Add(models.DefaultEventDuration), false, "UTC", "", "{}", "", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		// The owner isn't registered as an attendee of their own event
+		mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+			WithArgs(1).
+			WillReturnRows(mockRows(e))
+
+		body, _ := json.Marshal(map[string]interface{}{
+			"userId":      99, // ignored in favour of the authenticated user
+			"name":        e.Name,
+			"description": e.Description,
+			"startDate":   e.StartDate,
+		})
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(body))
+		app.routes().ServeHTTP(w, authorize(t, app, req, e.UserID))
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid event", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		expectOwner(mock, 1, true)
+
+		w := httptest.NewRecorder()
+		body := bytes.NewBufferString(`{"name": "short"}`)
+		req := httptest.NewRequest(http.MethodPost, "/events", body)
+		app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Owner no longer exists", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		e := testEvent()
+		expectOwner(mock, e.UserID, false)
+
+		body, _ := json.Marshal(e)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(body))
+		app.routes().ServeHTTP(w, authorize(t, app, req, e.UserID))
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), "userId must refer to an existing user")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Owner deleted after the check", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		e := testEvent()
+		expectOwner(mock, e.UserID, true)
+
+		// The events' foreign key catches a user deleted in the meantime
+		mock.ExpectPrepare("INSERT INTO events").
+			ExpectQuery().
+			WillReturnError(&pgconn.PgError{
+				Code:   pgerrcode.ForeignKeyViolation,
+				Detail: `Key (user_id)=(1) is not present in table "users".`,
+			})
+
+		body, _ := json.Marshal(e)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(body))
+		app.routes().ServeHTTP(w, authorize(t, app, req, e.UserID))
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		var response errorJSON
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, map[string]string{"userId": "does not exist"}, response.Details)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Start date in the past", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		e := testEvent()
+		e.StartDate = time.Now().Add(-time.Hour)
+		expectOwner(mock, e.UserID, true)
+
+		body, _ := json.Marshal(e)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(body))
+		app.routes().ServeHTTP(w, authorize(t, app, req, e.UserID))
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), "startDate must be in the future")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		app, mock := newTestApp(t)
+
+		w := httptest.NewRecorder()
+		body := bytes.NewBufferString(`{"name": "A perfectly good name"}`)
+		app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/events", body))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestEditStartedEvent(t *testing.T) {
+	started := testEvent()
+	started.StartDate = time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	startedJSON, _ := json.Marshal(started)
+	past := time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+	}{
+		{"Patch that leaves the start alone", http.MethodPatch, `{"name": "A brand new name"}`, http.StatusOK},
+		{"Replace that keeps the start", http.MethodPut, string(startedJSON), http.StatusOK},
+		{"Moving it further into the past", http.MethodPatch, `{"startDate": "` + past + `"}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+				WithArgs(1).
+				WillReturnRows(mockRows(started))
+			if tt.method == http.MethodPut {
+				expectOwner(mock, started.UserID, true)
+			}
+			if tt.expectedStatus == http.StatusOK {
+				mock.ExpectPrepare("UPDATE events SET").
+					ExpectExec().
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+					WithArgs(1).
+					WillReturnRows(mockRows(started))
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/events/1", bytes.NewBufferString(tt.body))
+			app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPatchEventIfMatch(t *testing.T) {
+	expectUpdate := func(mock sqlmock.Sqlmock, rowsAffected int64) {
+		mock.ExpectPrepare("UPDATE events SET name = \\$1, version = version \\+ 1 WHERE id = \\$2 AND version = \\$3").
+			ExpectExec().
+			WithArgs("A brand new name", 1, 3).
+			WillReturnResult(sqlmock.NewResult(0, rowsAffected))
+	}
+
+	tests := []struct {
+		name           string
+		ifMatch        string
+		setup          func(mock sqlmock.Sqlmock)
+		expectedStatus int
+		expectedETag   string
+	}{
+		{
+			name:    "Matching version",
+			ifMatch: `"3"`,
+			setup: func(mock sqlmock.Sqlmock) {
+				expectUpdate(mock, 1)
+				updated := testEvent()
+				updated.Version = 4
+				mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+					WithArgs(1).
+					WillReturnRows(mockRows(updated))
+			},
+			expectedStatus: http.StatusOK,
+			expectedETag:   `"4"`,
+		},
+		{
+			name:           "Stale version",
+			ifMatch:        `"2"`,
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusPreconditionFailed,
+			expectedETag:   `"3"`,
+		},
+		{
+			name:           "Weak tags never match",
+			ifMatch:        `W/"3"`,
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusPreconditionFailed,
+			expectedETag:   `"3"`,
+		},
+		{
+			name:    "Modified between read and write",
+			ifMatch: `"1", "3"`,
+			setup: func(mock sqlmock.Sqlmock) {
+				expectUpdate(mock, 0)
+				mock.ExpectQuery("SELECT EXISTS").
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			},
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name: "Modified between read and write without If-Match",
+			setup: func(mock sqlmock.Sqlmock) {
+				expectUpdate(mock, 0)
+				mock.ExpectQuery("SELECT EXISTS").
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name: "Deleted between read and write",
+			setup: func(mock sqlmock.Sqlmock) {
+				expectUpdate(mock, 0)
+				mock.ExpectQuery("SELECT EXISTS").
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+				WithArgs(1).
+				WillReturnRows(mockRows(testEvent()))
+			tt.setup(mock)
+
+			w := httptest.NewRecorder()
+			// the version in the body is ignored in favour of the stored one
+			body := bytes.NewBufferString(`{"name": "A brand new name", "version": 99}`)
+			req := httptest.NewRequest(http.MethodPatch, "/events/1", body)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+			if tt.expectedETag != "" {
+				assert.Equal(t, tt.expectedETag, w.Header().Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestDeleteEvent(t *testing.T) {
+	app, mock := newTestApp(t)
+
+	mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(mockRows(testEvent()))
+	mock.ExpectPrepare("DELETE FROM events WHERE id = \\$1 AND version = \\$2").
+		ExpectExec().
+		WithArgs(1, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/events/1", nil)
+	app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListEventsTimeout(t *testing.T) {
+	t.Run("Default timeout", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		app.Repo.(*repository.SqlRepo).QueryTimeout = 10 * time.Millisecond
+		mock.ExpectQuery("SELECT (.+) FROM events").
+			WillDelayFor(time.Second).
+			WillReturnRows(mockRows(testEvent()))
+
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events", nil))
+
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	})
+
+	t.Run("Client disconnects", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		mock.ExpectQuery("SELECT (.+) FROM events").
+			WillDelayFor(time.Second).
+			WillReturnRows(mockRows(testEvent()))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		req := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+
+		start := time.Now()
+		app.routes().ServeHTTP(httptest.NewRecorder(), req)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+}
+
+func TestDeleteEventIfMatch(t *testing.T) {
+	t.Run("Stale version", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+			WithArgs(1).
+			WillReturnRows(mockRows(testEvent()))
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, "/events/1", nil)
+		req.Header.Set("If-Match", `"2"`)
+		app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Changed after the check", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+			WithArgs(1).
+			WillReturnRows(mockRows(testEvent()))
+		mock.ExpectPrepare("DELETE FROM events WHERE id = \\$1 AND version = \\$2").
+			ExpectExec().
+			WithArgs(1, 3).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT EXISTS").
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, "/events/1", nil)
+		req.Header.Set("If-Match", `"3"`)
+		app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"events-app/data/models"
+	"events-app/data/repository"
+	"expvar"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+var (
+	errNotFound    = errors.New("the requested resource could not be found")
+	errServerError = errors.New("the server encountered a problem and could not process your request")
+	errTimeout     = errors.New("the server took too long to process your request")
+)
+
+// readIDParam reads the {id} wildcard from the request path and parses it as a
+// positive integer.
+func readIDParam(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid id parameter")
+	}
+	return id, nil
+}
+
+// sendRepoError translates an error returned by the repository into an
+// appropriate error response. Errors we don't recognise are logged and
+// reported to the client as a generic server error so internals don't leak.
+func (app *application) sendRepoError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		app.SendErrorJSON(w, http.StatusGatewayTimeout, errTimeout)
+		return
+	case errors.Is(err, context.Canceled):
+		// The client has gone away, so there's nobody to respond to
+		return
+	}
+
+	if status, _, _ := repoErrorResponse(err); status != 0 {
+		app.SendErrorJSON(w, status, err)
+		return
+	}
+
+	log.Printf("repository error: %v", err)
+	app.SendErrorJSON(w, http.StatusInternalServerError, errServerError)
+}
+
+// validationContext returns a copy of ctx for validating models that are
+// written through repo, which the users they refer to are looked up in; see
+// models.ValidateModelContext.
+func validationContext(ctx context.Context, repo repository.DBRepo) context.Context {
+	return models.WithUserLookup(ctx, func(ctx context.Context, id int64) (bool, error) {
+		_, err := repo.GetUserByIDContext(ctx, id)
+		if errors.Is(err, repository.ErrNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+}
+
+// repoErrorResponse works out how to report one of the repository's errors
+// about the request itself: the status code, the error to show the client and
+// any per-field details. status is 0 if err isn't one of them. A model that
+// fails models.ValidateModel is reported like one the database refused, so
+// invalid values get the same status wherever they are caught.
+func repoErrorResponse(err error) (status int, public error, details map[string]string) {
+	var dupErr *repository.DuplicateError
+	var fkErr *repository.ForeignKeyError
+	var valErr *repository.ValidationError
+	var fieldErrs models.ValidationErrors
+
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return http.StatusNotFound, errNotFound, nil
+	case errors.As(err, &dupErr):
+		if dupErr.Field != "" {
+			details = map[string]string{dupErr.Field: "is already taken"}
+		}
+		return http.StatusConflict, dupErr, details
+	case errors.As(err, &fkErr):
+		if fkErr.Field != "" {
+			details = map[string]string{fkErr.Field: "does not exist"}
+		}
+		return http.StatusConflict, fkErr, details
+	case errors.As(err, &valErr):
+		return http.StatusUnprocessableEntity, valErr, valErr.Fields
+	case errors.As(err, &fieldErrs):
+		return http.StatusUnprocessableEntity, err, nil
+	case errors.Is(err, repository.ErrInvalidQuery),
+		errors.Is(err, repository.ErrInvalidField):
+		return http.StatusBadRequest, err, nil
+	case errors.Is(err, repository.ErrConflict),
+		errors.Is(err, repository.ErrAlreadyAttending),
+		errors.Is(err, repository.ErrNotAttending):
+		return http.StatusConflict, err, nil
+	}
+	return 0, nil, nil
+}
+
+// debugVars serves the metrics published through expvar, such as the
+// repository's transaction retry counts. They include the command line the
+// server was started with, so only admins may see them.
+func (app *application) debugVars(w http.ResponseWriter, r *http.Request) {
+	if !app.authorize(w, r, adminOnly) {
+		return
+	}
+	expvar.Handler().ServeHTTP(w, r)
+}
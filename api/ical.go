@@ -0,0 +1,368 @@
+package main
+
+import (
+	"errors"
+	"events-app/data/models"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// calendarProdID identifies us as the product that made the calendars we
+// export.
+const calendarProdID = "-//events-app//Events API//EN"
+
+// zoneHorizon is how far past now the time zones in exported calendars spell
+// out their offset changes. Subscribed clients fetch feeds again long before
+// then.
+const zoneHorizon = 2 * 365 * 24 * time.Hour
+
+const (
+	icalDateTime = "20060102T150405"
+	icalDate     = "20060102"
+)
+
+// eventUID is the UID an event keeps in every calendar it is exported to, so
+// clients update it rather than add it again. Imported events keep the UID
+// they came with.
+func eventUID(e models.Event) string {
+	if e.UID != "" {
+		return e.UID
+	}
+	return fmt.Sprintf("event-%d@events-app", e.ID)
+}
+
+// icalFormat writes a time as an event's DTSTART, DTEND, EXDATE or
+// RECURRENCE-ID value, with the parameters it needs.
+type icalFormat func(t time.Time) (string, []ics.PropertyParameter)
+
+// calendarBuilder writes events into an RFC 5545 calendar. It defines the
+// time zones recurring events are written in, since their rule has to be
+// expanded in the event's own zone to follow its offset changes.
+type calendarBuilder struct {
+	cal *ics.Calendar
+	// zones holds the zones used, by name, and the earliest time they are
+	// used at
+	zones map[string]time.Time
+	now   time.Time
+}
+
+func newCalendarBuilder(name string) *calendarBuilder {
+	cal := ics.NewCalendar()
+	cal.SetProductId(calendarProdID)
+	cal.SetCalscale("GREGORIAN")
+	if name != "" {
+		cal.SetXWRCalName(name)
+	}
+	return &calendarBuilder{cal: cal, zones: make(map[string]time.Time), now: time.Now()}
+}
+
+// addEvent writes the event as a VEVENT. A recurring event keeps its rule and
+// exception dates; cancelled occurrences are added to the exception dates,
+// and moved ones become VEVENTs of their own with the event's UID and a
+// RECURRENCE-ID.
+func (b *calendarBuilder) addEvent(e models.Event, overrides []models.OccurrenceOverride) {
+	format := b.format(e)
+	vevent := b.addVEvent(e, format, e.StartDate, e.EndDate)
+	if e.RRule == "" {
+		return
+	}
+
+	vevent.AddRrule(e.RRule)
+	for _, t := range e.ExDates {
+		value, params := format(t)
+		vevent.AddExdate(value, params...)
+	}
+	for _, o := range overrides {
+		if o.Cancelled {
+			value, params := format(o.OriginalStart)
+			vevent.AddExdate(value, params...)
+			continue
+		}
+		moved := b.addVEvent(e, format, o.StartDate, o.EndDate)
+		value, params := format(o.OriginalStart)
+		moved.SetProperty(ics.ComponentPropertyRecurrenceId, value, params...)
+	}
+}
+
+func (b *calendarBuilder) addVEvent(e models.Event, format icalFormat, start, end time.Time) *ics.VEvent {
+	vevent := b.cal.AddEvent(eventUID(e))
+	// Events don't record when they were last changed, so DTSTAMP is when
+	// they were created; SEQUENCE tells clients they've changed since
+	vevent.SetDtStampTime(e.CreatedAt)
+	vevent.SetCreatedTime(e.CreatedAt)
+	// Versions count from 1, sequences from 0
+	vevent.SetSequence(int(e.Version - 1))
+
+	value, params := format(start)
+	vevent.SetProperty(ics.ComponentPropertyDtStart, value, params...)
+	value, params = format(end)
+	vevent.SetProperty(ics.ComponentPropertyDtEnd, value, params...)
+	vevent.SetSummary(e.Name)
+	vevent.SetDescription(e.Description)
+	return vevent
+}
+
+// format picks how the event's times are written: as dates in its zone if it
+// lasts all day, as local times in its zone if it recurs, and in UTC
+// otherwise.
+func (b *calendarBuilder) format(e models.Event) icalFormat {
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	switch {
+	case e.AllDay:
+		return func(t time.Time) (string, []ics.PropertyParameter) {
+			return t.In(loc).Format(icalDate), []ics.PropertyParameter{ics.WithValue(string(ics.ValueDataTypeDate))}
+		}
+	case e.RRule != "" && loc != time.UTC:
+		if first, ok := b.zones[loc.String()]; !ok || e.StartDate.Before(first) {
+			b.zones[loc.String()] = e.StartDate
+		}
+		return func(t time.Time) (string, []ics.PropertyParameter) {
+			return t.In(loc).Format(icalDateTime), []ics.PropertyParameter{ics.WithTZID(loc.String())}
+		}
+	default:
+		return func(t time.Time) (string, []ics.PropertyParameter) {
+			return t.UTC().Format(icalDateTime + "Z"), nil
+		}
+	}
+}
+
+// calendar returns the calendar, with a VTIMEZONE for each zone its events
+// were written in.
+func (b *calendarBuilder) calendar() *ics.Calendar {
+	names := make([]string, 0, len(b.zones))
+	for name := range b.zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// VTIMEZONEs come first, so they are defined before events refer to them
+	events := b.cal.Components
+	b.cal.Components = nil
+	for _, name := range names {
+		loc, _ := time.LoadLocation(name)
+		from := b.zones[name]
+		to := b.now
+		if from.After(to) {
+			to = from
+		}
+		b.cal.Components = append(b.cal.Components, vtimezone(loc, from, to.Add(zoneHorizon)))
+	}
+	b.cal.Components = append(b.cal.Components, events...)
+	return b.cal
+}
+
+// vtimezone describes loc between from and to: the offset in effect at from,
+// then every change of offset until to.
+func vtimezone(loc *time.Location, from, to time.Time) *ics.VTimezone {
+	tz := ics.NewTimezone(loc.String())
+	from = from.In(loc)
+	_, offset := from.Zone()
+	tz.Components = append(tz.Components, observance(from, offset))
+
+	// Offsets never change more than once a day, so look for changes a day at
+	// a time and narrow each one down to the second
+	for t := from; t.Before(to); {
+		next := t.Add(24 * time.Hour)
+		if _, o := next.Zone(); o != offset {
+			lo, hi := t, next
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2)
+				if _, o := mid.Zone(); o == offset {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			tz.Components = append(tz.Components, observance(hi.Truncate(time.Second), offset))
+			_, offset = hi.Zone()
+		}
+		t = next
+	}
+	return tz
+}
+
+// observance is the STANDARD or DAYLIGHT part of a VTIMEZONE starting at t,
+// when the offset changes from offsetFrom to t's.
+func observance(t time.Time, offsetFrom int) ics.Component {
+	name, offsetTo := t.Zone()
+	var c ics.ComponentBase
+	// Its start is given in the local time before the change
+	c.AddProperty(ics.ComponentPropertyDtStart, t.UTC().Add(time.Duration(offsetFrom)*time.Second).Format(icalDateTime))
+	c.AddProperty(ics.ComponentProperty(ics.PropertyTzoffsetfrom), utcOffset(offsetFrom))
+	c.AddProperty(ics.ComponentProperty(ics.PropertyTzoffsetto), utcOffset(offsetTo))
+	c.AddProperty(ics.ComponentProperty(ics.PropertyTzname), name)
+	if t.IsDST() {
+		return &ics.Daylight{ComponentBase: c}
+	}
+	return &ics.Standard{ComponentBase: c}
+}
+
+// utcOffset formats an offset in seconds east of UTC, e.g. +0530.
+func utcOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	s := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		s += fmt.Sprintf("%02d", seconds%60)
+	}
+	return s
+}
+
+// parseCalendar reads the VEVENTs of an iCalendar file as events, taking their
+// UID, SUMMARY as the name, DESCRIPTION, DTSTART, DTEND, RRULE and EXDATEs. An
+// event is in the zone its DTSTART names, or else the calendar's
+// X-WR-TIMEZONE, or else UTC; times that name no zone are read in it. A VEVENT
+// with a RECURRENCE-ID is an occurrence of the recurring event with its UID,
+// moved to its DTSTART; the entry has the RECURRENCE-ID, and is left for
+// importEntries to match up with that event. The events have no owner, and
+// haven't been validated.
+func parseCalendar(r io.Reader) ([]importEntry, error) {
+	cal, err := ics.ParseCalendar(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid iCalendar file: %w", err)
+	}
+
+	loc := time.UTC
+	for _, p := range cal.CalendarProperties {
+		if p.IANAToken == string(ics.PropertyXWRTimezone) {
+			if l, err := time.LoadLocation(p.Value); err == nil && p.Value != "Local" {
+				loc = l
+			}
+		}
+	}
+
+	var entries []importEntry
+	for _, vevent := range cal.Events() {
+		e, err := readVEvent(vevent, loc)
+		entry := importEntry{Event: e, Err: err}
+		if p := vevent.GetProperty(ics.ComponentPropertyRecurrenceId); p != nil {
+			var idErr error
+			if entry.RecurrenceID, _, idErr = icalTime(p, loc); idErr != nil && err == nil {
+				entry.Err = fmt.Errorf("invalid RECURRENCE-ID: %w", idErr)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func readVEvent(vevent *ics.VEvent, loc *time.Location) (models.Event, error) {
+	text := func(prop ics.ComponentProperty) string {
+		if p := vevent.GetProperty(prop); p != nil {
+			return strings.TrimSpace(p.Value)
+		}
+		return ""
+	}
+	e := models.Event{
+		UID:         text(ics.ComponentPropertyUniqueId),
+		Name:        text(ics.ComponentPropertySummary),
+		Description: text(ics.ComponentPropertyDescription),
+		Timezone:    loc.String(),
+	}
+
+	// Without a DTSTART the event is left to fail validation as having no
+	// start
+	start := vevent.GetProperty(ics.ComponentPropertyDtStart)
+	if start == nil {
+		return e, nil
+	}
+	if tzid := start.ICalParameters[string(ics.ParameterTzid)]; len(tzid) == 1 {
+		e.Timezone = tzid[0]
+	}
+	var err error
+	if e.StartDate, e.AllDay, err = icalTime(start, loc); err != nil {
+		return e, fmt.Errorf("invalid DTSTART: %w", err)
+	}
+	if end := vevent.GetProperty(ics.ComponentPropertyDtEnd); end != nil {
+		if e.EndDate, _, err = icalTime(end, loc); err != nil {
+			return e, fmt.Errorf("invalid DTEND: %w", err)
+		}
+	}
+
+	// RFC 5545 leaves what more than one rule means open, and we can't
+	// store it anyway
+	switch rules := vevent.GetProperties(ics.ComponentPropertyRrule); len(rules) {
+	case 0:
+	case 1:
+		e.RRule = strings.TrimSpace(rules[0].Value)
+	default:
+		return e, errors.New("more than one RRULE")
+	}
+	for _, p := range vevent.GetProperties(ics.ComponentPropertyExdate) {
+		times, err := icalTimes(p, loc)
+		if err != nil {
+			return e, fmt.Errorf("invalid EXDATE: %w", err)
+		}
+		e.ExDates = append(e.ExDates, times...)
+	}
+	return e, nil
+}
+
+// icalTimes reads a property holding a comma-separated list of dates or
+// date-times, such as EXDATE, as icalTime reads a single one.
+func icalTimes(p *ics.IANAProperty, loc *time.Location) ([]time.Time, error) {
+	var times []time.Time
+	for _, value := range strings.Split(p.Value, ",") {
+		single := *p
+		single.Value = strings.TrimSpace(value)
+		t, _, err := icalTime(&single, loc)
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+// icalTime reads a DATE or DATE-TIME property, in the zone it names or else
+// in loc. allDay reports whether it is a date.
+func icalTime(p *ics.IANAProperty, loc *time.Location) (t time.Time, allDay bool, err error) {
+	if tzid := p.ICalParameters[string(ics.ParameterTzid)]; len(tzid) == 1 {
+		if loc, err = time.LoadLocation(tzid[0]); err != nil || tzid[0] == "Local" {
+			return time.Time{}, false, fmt.Errorf("unknown time zone: %s", tzid[0])
+		}
+	}
+
+	switch value := p.Value; {
+	case len(value) == len(icalDate):
+		t, err = time.ParseInLocation(icalDate, value, loc)
+		allDay = true
+	case strings.HasSuffix(value, "Z"):
+		t, err = time.Parse(icalDateTime+"Z", value)
+	default:
+		t, err = time.ParseInLocation(icalDateTime, value, loc)
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("expected a date or date-time, got %q", p.Value)
+	}
+	return t, allDay, nil
+}
+
+// sendCalendar writes the calendar built by b as the response.
+func (app *application) sendCalendar(w http.ResponseWriter, b *calendarBuilder) {
+	// RFC 5545 ends lines with CRLF, which the library doesn't by default
+	var buf strings.Builder
+	if err := b.calendar().SerializeTo(&buf, ics.WithNewLine("\r\n")); err != nil {
+		log.Printf("error writing calendar: %v", err)
+		app.SendErrorJSON(w, http.StatusInternalServerError, errServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(buf.String()))
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVTimezone(t *testing.T) {
+	tests := []struct {
+		name          string
+		zone          string
+		expectedLines []string
+	}{
+		{
+			name: "Daylight saving time",
+			zone: "America/New_York",
+			expectedLines: []string{
+				"TZID:America/New_York",
+				// The offset in effect from the start, then the changes in
+				// November and March
+				"BEGIN:DAYLIGHT",
+				"DTSTART:20241001T000000",
+				"BEGIN:STANDARD",
+				"DTSTART:20241103T020000",
+				"TZOFFSETFROM:-0400",
+				"TZOFFSETTO:-0500",
+				"TZNAME:EST",
+				"DTSTART:20250309T020000",
+			},
+		},
+		{
+			name: "Fixed offset",
+			zone: "Asia/Kolkata",
+			expectedLines: []string{
+				"TZID:Asia/Kolkata",
+				"TZOFFSETFROM:+0530",
+				"TZOFFSETTO:+0530",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := time.LoadLocation(tt.zone)
+			assert.NoError(t, err)
+			from := time.Date(2024, 10, 1, 0, 0, 0, 0, loc)
+
+			cal := ics.NewCalendar()
+			cal.Components = append(cal.Components, vtimezone(loc, from, from.AddDate(0, 6, 0)))
+			lines := strings.Split(cal.Serialize(ics.WithNewLine("\r\n")), "\r\n")
+			for _, line := range tt.expectedLines {
+				assert.Contains(t, lines, line)
+			}
+		})
+	}
+}
+
+func TestUTCOffset(t *testing.T) {
+	assert.Equal(t, "+0000", utcOffset(0))
+	assert.Equal(t, "+0530", utcOffset(5*3600+30*60))
+	assert.Equal(t, "-0500", utcOffset(-5*3600))
+	assert.Equal(t, "-002530", utcOffset(-(25*60 + 30)))
+}
+
+func TestParseCalendar(t *testing.T) {
+	file := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Test//Test//EN",
+		"X-WR-TIMEZONE:Europe/Berlin",
+		"BEGIN:VEVENT",
+		"UID:zoned@example.com",
+		"SUMMARY:Zoned",
+		"DESCRIPTION:Bring snacks\\, drinks",
+		"DTSTART;TZID=America/New_York:20241001T090000",
+		"DTEND;TZID=America/New_York:20241001T100000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:floating@example.com",
+		"SUMMARY:Floating",
+		"DTSTART:20241001T090000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:utc@example.com",
+		"SUMMARY:UTC",
+		"DTSTART:20241001T090000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:all-day@example.com",
+		"SUMMARY:All day",
+		"DTSTART;VALUE=DATE:20241001",
+		"DTEND;VALUE=DATE:20241003",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:broken@example.com",
+		"SUMMARY:Broken",
+		"DTSTART:tomorrow",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:weekly@example.com",
+		"SUMMARY:Weekly",
+		"DTSTART;TZID=America/New_York:20241001T090000",
+		"DTEND;TZID=America/New_York:20241001T100000",
+		"RRULE:FREQ=WEEKLY;COUNT=5",
+		"EXDATE;TZID=America/New_York:20241008T090000,20241015T090000",
+		"EXDATE:20241022T130000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:weekly@example.com",
+		"SUMMARY:Weekly",
+		"RECURRENCE-ID;TZID=America/New_York:20241029T090000",
+		"DTSTART;TZID=America/New_York:20241030T090000",
+		"DTEND;TZID=America/New_York:20241030T100000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:two-rules@example.com",
+		"SUMMARY:Two rules",
+		"DTSTART:20241001T090000Z",
+		"RRULE:FREQ=DAILY",
+		"RRULE:FREQ=WEEKLY",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+
+	entries, err := parseCalendar(strings.NewReader(file))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 8)
+
+	newYork, _ := time.LoadLocation("America/New_York")
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+
+	zoned := entries[0].Event
+	assert.NoError(t, entries[0].Err)
+	assert.Equal(t, "zoned@example.com", zoned.UID)
+	assert.Equal(t, "Bring snacks, drinks", zoned.Description)
+	assert.Equal(t, "America/New_York", zoned.Timezone)
+	assert.True(t, zoned.StartDate.Equal(time.Date(2024, 10, 1, 9, 0, 0, 0, newYork)))
+	assert.True(t, zoned.EndDate.Equal(time.Date(2024, 10, 1, 10, 0, 0, 0, newYork)))
+
+	floating := entries[1].Event
+	assert.Equal(t, "Europe/Berlin", floating.Timezone)
+	assert.True(t, floating.StartDate.Equal(time.Date(2024, 10, 1, 9, 0, 0, 0, berlin)))
+
+	assert.True(t, entries[2].Event.StartDate.Equal(time.Date(2024, 10, 1, 9, 0, 0, 0, time.UTC)))
+
+	allDay := entries[3].Event
+	assert.True(t, allDay.AllDay)
+	assert.True(t, allDay.StartDate.Equal(time.Date(2024, 10, 1, 0, 0, 0, 0, berlin)))
+	assert.True(t, allDay.EndDate.Equal(time.Date(2024, 10, 3, 0, 0, 0, 0, berlin)))
+
+	assert.ErrorContains(t, entries[4].Err, "invalid DTSTART")
+
+	weekly := entries[5]
+	assert.NoError(t, weekly.Err)
+	assert.True(t, weekly.RecurrenceID.IsZero())
+	assert.Equal(t, "FREQ=WEEKLY;COUNT=5", weekly.Event.RRule)
+	if assert.Len(t, weekly.Event.ExDates, 3) {
+		assert.True(t, weekly.Event.ExDates[0].Equal(time.Date(2024, 10, 8, 9, 0, 0, 0, newYork)))
+		assert.True(t, weekly.Event.ExDates[1].Equal(time.Date(2024, 10, 15, 9, 0, 0, 0, newYork)))
+		assert.True(t, weekly.Event.ExDates[2].Equal(time.Date(2024, 10, 22, 9, 0, 0, 0, newYork)))
+	}
+
+	moved := entries[6]
+	assert.NoError(t, moved.Err)
+	assert.Equal(t, "weekly@example.com", moved.Event.UID)
+	assert.True(t, moved.RecurrenceID.Equal(time.Date(2024, 10, 29, 9, 0, 0, 0, newYork)))
+	assert.True(t, moved.Event.StartDate.Equal(time.Date(2024, 10, 30, 9, 0, 0, 0, newYork)))
+
+	assert.ErrorContains(t, entries[7].Err, "more than one RRULE")
+
+	_, err = parseCalendar(strings.NewReader("not a calendar"))
+	assert.Error(t, err)
+}
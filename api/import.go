@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"events-app/data/models"
+	"events-app/data/repository"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// maxImportSize is the largest file an import accepts.
+const maxImportSize = 10 << 20
+
+// What became of each event in an import
+const (
+	importCreated   = "created"
+	importDuplicate = "duplicate"
+	importInvalid   = "invalid"
+	// importMoved is an occurrence of an imported recurring event that was
+	// moved, which is saved as an override of it
+	importMoved = "moved"
+	// importSkipped is a valid event of an atomic import that was rejected
+	importSkipped = "skipped"
+)
+
+// The modes an import can be run in, chosen with the mode query parameter
+const (
+	// importBestEffort creates the valid events and reports the rest
+	importBestEffort = "bestEffort"
+	// importAtomic creates the events only if every one of them is valid
+	importAtomic = "atomic"
+)
+
+var (
+	errNoImportFile      = errors.New("expected a file in the file field")
+	errInvalidImportMode = errors.New("mode must be atomic or bestEffort")
+	errImportRejected    = errors.New("nothing was imported, as some of the events are invalid")
+	errNoRecurringEvent  = errors.New("RECURRENCE-ID names no valid recurring event in the file with its UID")
+	errNotAnOccurrence   = errors.New("RECURRENCE-ID is not an occurrence of the event with its UID")
+)
+
+// importEntry is an event read from an imported file. Err says why it
+// couldn't be read as an event.
+type importEntry struct {
+	// Row is the line of a CSV file the event was read from
+	Row   int
+	Event models.Event
+	Err   error
+	// RecurrenceID is set if the event is an occurrence of the recurring
+	// event with its UID, moved from that start to the event's times
+	RecurrenceID time.Time
+}
+
+// importItem reports what became of one VEVENT of an imported calendar, or
+// one row of an imported CSV file. ID is the event created, or for a duplicate
+// the event that already has its UID. An invalid event's errors are listed as
+// when creating it through the API. A moved occurrence's ID is the event it
+// belongs to.
+type importItem struct {
+	Row    int                     `json:"row,omitempty"`
+	UID    string                  `json:"uid,omitempty"`
+	Name   string                  `json:"name,omitempty"`
+	Status string                  `json:"status"`
+	ID     int64                   `json:"id,omitempty"`
+	Error  string                  `json:"error,omitempty"`
+	Errors models.ValidationErrors `json:"errors,omitempty"`
+}
+
+// importReport lists what became of each event of an imported file, in the
+// order of the file, with a count of each outcome.
+type importReport struct {
+	Created    int          `json:"created"`
+	Duplicates int          `json:"duplicates"`
+	Invalid    int          `json:"invalid"`
+	Moved      int          `json:"moved"`
+	Items      []importItem `json:"items"`
+}
+
+// importFile returns the file sent to an import: the body, or the file field
+// of a multipart form.
+func importFile(w http.ResponseWriter, r *http.Request) (io.ReadCloser, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, errNoImportFile
+		}
+		return file, nil
+	}
+	return r.Body, nil
+}
+
+// importAndReport creates the caller's events read from an imported file, in
+// the mode named by the mode query parameter, bestEffort by default, and
+// responds with the report. An atomic import that is rejected responds with
+// 422 and the report.
+func (app *application) importAndReport(w http.ResponseWriter, r *http.Request, entries []importEntry) {
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != importBestEffort && mode != importAtomic {
+		app.SendErrorJSON(w, http.StatusBadRequest, errInvalidImportMode)
+		return
+	}
+
+	a, _ := actorFromContext(r.Context())
+	report, err := app.importEntries(r.Context(), a.ID, entries, mode == importAtomic)
+	if errors.Is(err, errImportRejected) {
+		res := errorJSON{Status: "fail", Message: err.Error(), Data: map[string]interface{}{"import": report}}
+		marshalAndSend(w, res, http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	app.SendSuccessJSON(w, http.StatusOK, report, "import")
+}
+
+// importEntries creates the user's events read from an imported file, in one
+// transaction. Events that fail validation are skipped, including those that
+// start in the past, as any new event must start in the future. So are
+// duplicates: events with the UID of one of the user's events, or of one
+// earlier in the file. An occurrence moved with a RECURRENCE-ID is saved as an
+// override of the first recurring event in the file with its UID, keeping
+// only its times; if that event is a duplicate, so is the occurrence.
+// If atomic is set and any event is invalid, nothing is created and it returns
+// the report with errImportRejected.
+func (app *application) importEntries(ctx context.Context, userID int64, entries []importEntry, atomic bool) (importReport, error) {
+	events := make([]models.Event, len(entries))
+	items := make([]importItem, len(entries))
+	var uids []string
+	invalid := 0
+	setInvalid := func(i int, err error) {
+		items[i].Status = importInvalid
+		items[i].Error = err.Error()
+		errors.As(err, &items[i].Errors)
+		invalid++
+	}
+
+	// Events first, so each moved occurrence can be checked against the
+	// event it belongs to, wherever in the file that is
+	vctx := validationContext(ctx, app.Repo)
+	// recurring holds the index of the first recurring event with each UID
+	recurring := make(map[string]int)
+	for i, entry := range entries {
+		e := entry.Event
+		e.UserID = userID
+		events[i] = e
+		items[i] = importItem{Row: entry.Row, UID: e.UID, Name: e.Name}
+		if !entry.RecurrenceID.IsZero() {
+			continue
+		}
+
+		err := entry.Err
+		if err == nil {
+			err = models.ValidateModelContext(vctx, &e)
+		}
+		if errors.Is(err, models.ErrValidationIncomplete) {
+			return importReport{}, err
+		}
+		if err != nil {
+			setInvalid(i, err)
+			continue
+		}
+		if e.UID != "" {
+			uids = append(uids, e.UID)
+			if _, ok := recurring[e.UID]; !ok && e.RRule != "" {
+				recurring[e.UID] = i
+			}
+		}
+	}
+
+	// Each moved occurrence belongs to the event it was checked against, by
+	// index, which needn't be the first event with its UID
+	overrides := make(map[int]models.OccurrenceOverride)
+	masterOf := make(map[int]int)
+	for i, entry := range entries {
+		if entry.RecurrenceID.IsZero() {
+			continue
+		}
+		if entry.Err != nil {
+			setInvalid(i, entry.Err)
+			continue
+		}
+		m, ok := recurring[entry.Event.UID]
+		if !ok {
+			setInvalid(i, errNoRecurringEvent)
+			continue
+		}
+		// As it will be stored, with its default end
+		written, _ := events[m].PreWrite()
+		master := written.(models.Event)
+		if !master.IsOccurrence(entry.RecurrenceID) {
+			setInvalid(i, errNotAnOccurrence)
+			continue
+		}
+		o := models.OccurrenceOverride{
+			OriginalStart: entry.RecurrenceID,
+			StartDate:     entry.Event.StartDate,
+			EndDate:       entry.Event.EndDate,
+		}
+		if o.EndDate.IsZero() && !o.StartDate.IsZero() {
+			o.EndDate = o.StartDate.Add(master.EndDate.Sub(master.StartDate))
+		}
+		if err := models.ValidateModel(&o); err != nil {
+			setInvalid(i, err)
+			continue
+		}
+		overrides[i] = o
+		masterOf[i] = m
+	}
+
+	if atomic && invalid > 0 {
+		report := importReport{Invalid: invalid, Items: items}
+		for i := range report.Items {
+			if report.Items[i].Status == "" {
+				report.Items[i].Status = importSkipped
+			}
+		}
+		return report, errImportRejected
+	}
+
+	var report importReport
+	err := app.Repo.WithTx(ctx, func(tx repository.DBRepo) error {
+		// The transaction may be retried, so start the report afresh
+		report = importReport{Invalid: invalid, Items: append([]importItem(nil), items...)}
+
+		existing, err := tx.GetEventIDsByUIDContext(ctx, userID, uids)
+		if err != nil {
+			return err
+		}
+
+		// An event with the UID of one earlier in the file is a duplicate of
+		// the event created for that one
+		var create []models.Model
+		var createdItems []int
+		first := make(map[string]int)
+		duplicateOf := make(map[int]int)
+		for i, e := range events {
+			item := &report.Items[i]
+			if _, ok := overrides[i]; ok || item.Status == importInvalid {
+				continue
+			}
+			if e.UID != "" {
+				if id, ok := existing[e.UID]; ok {
+					item.Status, item.ID = importDuplicate, id
+					report.Duplicates++
+					continue
+				}
+				if j, ok := first[e.UID]; ok {
+					item.Status = importDuplicate
+					duplicateOf[i] = j
+					report.Duplicates++
+					continue
+				}
+				first[e.UID] = len(create)
+			}
+			createdItems = append(createdItems, i)
+			create = append(create, e)
+		}
+
+		ids, err := tx.CreateManyContext(ctx, create)
+		if err != nil {
+			return err
+		}
+		for j, id := range ids {
+			item := &report.Items[createdItems[j]]
+			item.Status, item.ID = importCreated, id
+		}
+		for i, j := range duplicateOf {
+			report.Items[i].ID = ids[j]
+		}
+		report.Created = len(ids)
+
+		// In the order of the file, so the overrides are saved in a
+		// predictable order
+		for i := range report.Items {
+			o, ok := overrides[i]
+			if !ok {
+				continue
+			}
+			// An occurrence of an event that wasn't created, as it duplicates
+			// one in the db or earlier in the file, is a duplicate too
+			item := &report.Items[i]
+			master := report.Items[masterOf[i]]
+			if master.Status == importDuplicate {
+				item.Status, item.ID = importDuplicate, master.ID
+				report.Duplicates++
+				continue
+			}
+			o.EventID = master.ID
+			if _, err := tx.SaveOccurrenceOverrideContext(ctx, o); err != nil {
+				return err
+			}
+			item.Status, item.ID = importMoved, o.EventID
+			report.Moved++
+		}
+		return nil
+	})
+	return report, err
+}
@@ -12,16 +12,28 @@ import (
 type successJSON struct {
 	Status string      `json:"status"`
 	Data   interface{} `json:"data"`
+	// Meta describes the page a list response holds; see pageMeta
+	Meta interface{} `json:"meta,omitempty"`
 }
 
 type errorJSON struct {
-	Status  string `json:"status"`
-	Message string `json:"message"`
+	Status  string                  `json:"status"`
+	Message string                  `json:"message"`
+	Details map[string]string       `json:"details,omitempty"`
+	Errors  models.ValidationErrors `json:"errors,omitempty"`
+	// Data holds anything else the client needs to make sense of the failure
+	Data interface{} `json:"data,omitempty"`
 }
 
 func marshalAndSend(w http.ResponseWriter, jsonRes interface{}, statusCode int) error {
-	switch jsonRes.(type) {
+	switch res := jsonRes.(type) {
 	case successJSON, errorJSON:
+		// fields tagged writeOnly (e.g. passwords) must never be sent back out
+		if s, ok := res.(successJSON); ok {
+			s.Data = models.StripWriteOnlyFields(s.Data)
+			jsonRes = s
+		}
+
 		payload, err := json.Marshal(jsonRes)
 		if err != nil {
 			return err
@@ -54,8 +66,17 @@ func (app *application) SendSuccessJSON(w http.ResponseWriter, statusCode int, d
 	return marshalAndSend(w, jsonRes, statusCode)
 }
 
+// SendErrorJSON responds with the error's message. The repository's errors
+// about the request (see repoErrorResponse) override statusCode with their own
+// status, and may add details about the fields at fault. A model that failed
+// validation has each failed rule listed under "errors".
 func (app *application) SendErrorJSON(w http.ResponseWriter, statusCode int, err error) error {
 	jsonRes := errorJSON{}
+	if status, public, details := repoErrorResponse(err); status != 0 {
+		statusCode, err, jsonRes.Details = status, public, details
+	}
+	errors.As(err, &jsonRes.Errors)
+
 	if statusCode >= 500 {
 		jsonRes.Status = "error"
 	} else {
@@ -2,9 +2,12 @@ package main
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"events-app/data/models"
+	"events-app/data/repository"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -47,13 +50,13 @@ func TestReadJSON(t *testing.T) {
 		{
 			name:          "Missing Required Field",
 			body:          `{"email":"", "password":"password"}`,
-			expectedError: "Key: 'User.Email' Error:Field validation for 'Email' failed on the 'required' tag",
+			expectedError: "email is required",
 			validationReq: true,
 		},
 		{
 			name:          "Invalid Field",
 			body:          `{"email":"example@hello", "password":"password"}`,
-			expectedError: "Key: 'User.Email' Error:Field validation for 'Email' failed on the 'email' tag",
+			expectedError: "email must be a valid email address",
 			validationReq: true,
 		},
 	}
@@ -119,6 +122,17 @@ func TestSendSuccessJSON(t *testing.T) {
 	}
 }
 
+func TestSendSuccessJSON_WriteOnlyFields(t *testing.T) {
+	app := &application{}
+	user := models.User{ID: 1, Email: "example@hello.com", Password: "password"}
+
+	w := httptest.NewRecorder()
+	err := app.SendSuccessJSON(w, http.StatusOK, []models.User{user}, "users")
+	assert.NoError(t, err)
+	assert.NotContains(t, w.Body.String(), "password")
+	assert.Contains(t, w.Body.String(), `"email":"example@hello.com"`)
+}
+
 func TestSendErrorJSON(t *testing.T) {
 	app := &application{}
 	tests := []struct {
@@ -157,3 +171,70 @@ func TestSendErrorJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestSendErrorJSON_RepositoryErrors(t *testing.T) {
+	app := &application{}
+	tests := []struct {
+		name            string
+		er              error
+		expectedCode    int
+		expectedMessage string
+		expectedDetails map[string]string
+	}{
+		{
+			name:            "Not found",
+			er:              fmt.Errorf("%w: %w", repository.ErrNotFound, sql.ErrNoRows),
+			expectedCode:    http.StatusNotFound,
+			expectedMessage: errNotFound.Error(),
+		},
+		{
+			name:            "Duplicate",
+			er:              fmt.Errorf("error executing query: %w", &repository.DuplicateError{Field: "email"}),
+			expectedCode:    http.StatusConflict,
+			expectedMessage: "a record with this email already exists",
+			expectedDetails: map[string]string{"email": "is already taken"},
+		},
+		{
+			name:            "Foreign key",
+			er:              &repository.ForeignKeyError{Field: "userId"},
+			expectedCode:    http.StatusConflict,
+			expectedMessage: "the record referred to by userId does not exist",
+			expectedDetails: map[string]string{"userId": "does not exist"},
+		},
+		{
+			name:            "Validation",
+			er:              &repository.ValidationError{Fields: map[string]string{"status": "is not an allowed value"}},
+			expectedCode:    http.StatusUnprocessableEntity,
+			expectedMessage: "invalid value for status",
+			expectedDetails: map[string]string{"status": "is not an allowed value"},
+		},
+		{
+			name:            "Model validation",
+			er:              models.ValidationErrors{{Field: "name", Rule: "required", Message: "name is required"}},
+			expectedCode:    http.StatusUnprocessableEntity,
+			expectedMessage: "name is required",
+		},
+		{
+			name:            "Invalid query",
+			er:              fmt.Errorf("%w: invalid sort value: colour", repository.ErrInvalidQuery),
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: "invalid query: invalid sort value: colour",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			err := app.SendErrorJSON(w, http.StatusInternalServerError, tt.er)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			var response errorJSON
+			err = json.NewDecoder(w.Body).Decode(&response)
+			assert.NoError(t, err)
+			assert.Equal(t, "fail", response.Status)
+			assert.Equal(t, tt.expectedMessage, response.Message)
+			assert.Equal(t, tt.expectedDetails, response.Details)
+		})
+	}
+}
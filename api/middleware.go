@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const actorContextKey = contextKey("actor")
+
+var errAuthRequired = errors.New("you must be authenticated to access this resource")
+
+// authenticate identifies the user behind a bearer access token, if the
+// request carries one, and stores them in the request context; read it with
+// actorFromContext. Anonymous requests are passed through to next untouched,
+// but a token that is present and invalid is rejected.
+func (app *application) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next(w, r)
+			return
+		}
+
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.SendErrorJSON(w, http.StatusUnauthorized, errAuthRequired)
+			return
+		}
+
+		a, err := app.parseAccessToken(token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			app.SendErrorJSON(w, http.StatusUnauthorized, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), actorContextKey, a)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// requireAuth only lets requests carrying a valid bearer access token through
+// to next. The authenticated user is stored in the request context; read it
+// with actorFromContext.
+func (app *application) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return app.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := actorFromContext(r.Context()); !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.SendErrorJSON(w, http.StatusUnauthorized, errAuthRequired)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// actorFromContext returns the user authenticated by authenticate or
+// requireAuth.
+func actorFromContext(ctx context.Context) (actor, bool) {
+	a, ok := ctx.Value(actorContextKey).(actor)
+	return a, ok
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"events-app/data/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var errNoOccurrence = errors.New("the event has no occurrence starting at that time")
+
+// readStartParam reads the {start} wildcard from the request path, the start of
+// an occurrence as its event's rule gives it, e.g. 2024-06-01T18:00:00+02:00.
+func readStartParam(r *http.Request) (time.Time, error) {
+	start, err := time.Parse(time.RFC3339Nano, r.PathValue("start"))
+	if err != nil {
+		return time.Time{}, errors.New("invalid start parameter, expected e.g. 2024-06-01T18:00:00+02:00")
+	}
+	return start, nil
+}
+
+// listOccurrences handles GET /events/{id}/occurrences, listing the occurrences
+// of one event within the interval given by the during parameter, a page at a
+// time.
+func (app *application) listOccurrences(w http.ResponseWriter, r *http.Request) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	params, err := queryParamsFromURL(r.URL.Query())
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	loc, ok := app.readLocation(w, r)
+	if !ok {
+		return
+	}
+	if loc != nil {
+		params["tz"] = loc.String()
+	}
+	// Only ever expand the event in the path
+	params["id"] = strconv.FormatInt(id, 10)
+
+	page, err := readPage(params)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	app.sendOccurrences(w, r, page, params, loc, "occurrences")
+}
+
+// sendOccurrences responds with a page of the occurrences matching params,
+// in loc, along with the page's meta and links as sendPage gives them.
+func (app *application) sendOccurrences(w http.ResponseWriter, r *http.Request, page pageMeta, params map[string]string, loc *time.Location, wrap string) {
+	occurrences, total, err := app.Repo.QueryOccurrencesContext(r.Context(), params)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+	for i := range occurrences {
+		occurrences[i] = occurrenceIn(occurrences[i], loc)
+	}
+
+	page.Total = total
+	app.sendPage(w, r, page, occurrences, wrap)
+}
+
+// overrideOccurrence handles PUT /events/{id}/occurrences/{start}. The payload
+// either cancels the occurrence, {"cancelled": true}, or moves it, e.g.
+// {"startDate": "2024-06-02T18:00:00+02:00"}; a moved occurrence keeps the
+// event's duration unless given an endDate.
+func (app *application) overrideOccurrence(w http.ResponseWriter, r *http.Request) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	originalStart, err := readStartParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	event, err := app.Repo.GetEventByIDContext(r.Context(), id)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	if !app.authorize(w, r, canModifyEvent(event)) {
+		return
+	}
+	if event.RRule == "" || !event.IsOccurrence(originalStart) {
+		app.SendErrorJSON(w, http.StatusNotFound, errNoOccurrence)
+		return
+	}
+	loc, ok := app.readLocation(w, r)
+	if !ok {
+		return
+	}
+
+	var override models.OccurrenceOverride
+	if err := app.ReadJSON(w, r, &override, false); err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	override.EventID = id
+	override.OriginalStart = originalStart
+	duration := event.EndDate.Sub(event.StartDate)
+	if override.Cancelled {
+		override.StartDate = originalStart
+		override.EndDate = originalStart.Add(duration)
+	} else if override.EndDate.IsZero() && !override.StartDate.IsZero() {
+		override.EndDate = override.StartDate.Add(duration)
+	}
+	if err := models.ValidateModel(&override); err != nil {
+		app.SendErrorJSON(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	saved, err := app.Repo.SaveOccurrenceOverrideContext(r.Context(), override)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+	if loc != nil {
+		saved.OriginalStart = saved.OriginalStart.In(loc)
+		saved.StartDate = saved.StartDate.In(loc)
+		saved.EndDate = saved.EndDate.In(loc)
+		saved.CreatedAt = saved.CreatedAt.In(loc)
+	}
+
+	app.SendSuccessJSON(w, http.StatusOK, saved, "override")
+}
+
+// restoreOccurrence handles DELETE /events/{id}/occurrences/{start}, undoing
+// any cancellation or move of the occurrence.
+func (app *application) restoreOccurrence(w http.ResponseWriter, r *http.Request) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	originalStart, err := readStartParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	event, err := app.Repo.GetEventByIDContext(r.Context(), id)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	if !app.authorize(w, r, canModifyEvent(event)) {
+		return
+	}
+
+	if err := app.Repo.DeleteOccurrenceOverrideContext(r.Context(), id, originalStart); err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	app.SendSuccessJSON(w, http.StatusOK, nil)
+}
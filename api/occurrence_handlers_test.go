@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"events-app/data/models"
+	"events-app/data/repository"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// weeklyEvent is a two-hour event repeated every Tuesday at 18:00 UTC for six
+// weeks from the 1st of October 2024.
+func weeklyEvent() models.Event {
+	e := testEvent()
+	e.StartDate = time.Date(2024, 10, 1, 18, 0, 0, 0, time.UTC)
+	e.EndDate = e.StartDate.Add(2 * time.Hour)
+	e.RRule = "FREQ=WEEKLY;COUNT=6"
+	return e
+}
+
+func TestListOccurrences(t *testing.T) {
+	e := weeklyEvent()
+	cancelled := models.OccurrenceOverride{
+		ID:            1,
+		EventID:       e.ID,
+		OriginalStart: e.StartDate.AddDate(0, 0, 7),
+		Cancelled:     true,
+		StartDate:     e.StartDate.AddDate(0, 0, 7),
+		EndDate:       e.EndDate.AddDate(0, 0, 7),
+	}
+
+	expectEvents := func(mock sqlmock.Sqlmock, query string) {
+		mock.ExpectQuery(query).
+			WillReturnRows(mockRows(e))
+		mock.ExpectQuery("SELECT (.+) FROM occurrence_overrides WHERE event_id IN \\(\\$1\\)").
+			WithArgs(e.ID).
+			WillReturnRows(mockRows(cancelled))
+	}
+
+	tests := []struct {
+		name           string
+		path           string
+		setup          func(mock sqlmock.Sqlmock)
+		expectedStatus int
+		expectedStarts []string
+		expectedMeta   pageMeta
+		expectedLinks  string
+	}{
+		{
+			name: "Events during a window",
+			path: "/events?during=2024-10-01,2024-10-21",
+			setup: func(mock sqlmock.Sqlmock) {
+				expectEvents(mock, "SELECT (.+) FROM events WHERE start_date <= \\$1 AND \\(end_date >= \\$2 OR rrule <> ''\\)")
+			},
+			expectedStatus: http.StatusOK,
+			expectedStarts: []string{"2024-10-01T18:00:00Z", "2024-10-15T18:00:00Z"},
+			expectedMeta:   pageMeta{Total: 2, Limit: 10},
+			expectedLinks: `</events?during=2024-10-01%2C2024-10-21&limit=10&offset=0>; rel="first", ` +
+				`</events?during=2024-10-01%2C2024-10-21&limit=10&offset=0>; rel="last"`,
+		},
+		{
+			name: "Occurrences of one event",
+			path: "/events/1/occurrences?during=2024-10-01,2024-10-21&tz=Europe/Berlin",
+			setup: func(mock sqlmock.Sqlmock) {
+				expectEvents(mock, "SELECT (.+) FROM events WHERE (.*)\\bid = \\$\\d")
+			},
+			expectedStatus: http.StatusOK,
+			expectedStarts: []string{"2024-10-01T20:00:00+02:00", "2024-10-15T20:00:00+02:00"},
+			expectedMeta:   pageMeta{Total: 2, Limit: 10},
+			expectedLinks: `</events/1/occurrences?during=2024-10-01%2C2024-10-21&limit=10&offset=0&tz=Europe%2FBerlin>; rel="first", ` +
+				`</events/1/occurrences?during=2024-10-01%2C2024-10-21&limit=10&offset=0&tz=Europe%2FBerlin>; rel="last"`,
+		},
+		{
+			// A series that has ended still matches, but mustn't take up
+			// any of the page
+			name: "Page of occurrences",
+			path: "/events?during=2024-10-01,2024-10-31&limit=2&offset=1",
+			setup: func(mock sqlmock.Sqlmock) {
+				finished := weeklyEvent()
+				finished.ID = 2
+				finished.StartDate = finished.StartDate.AddDate(0, -1, 0)
+				finished.EndDate = finished.EndDate.AddDate(0, -1, 0)
+				finished.RRule = "FREQ=DAILY;COUNT=3"
+				mock.ExpectQuery("SELECT (.+) FROM events WHERE (.+) ORDER BY id LIMIT \\$3$").
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), repository.MaxOccurrenceEvents+1).
+					WillReturnRows(mockRows(e, finished))
+				mock.ExpectQuery("SELECT (.+) FROM occurrence_overrides WHERE event_id IN \\(\\$1,\\$2\\)").
+					WithArgs(e.ID, finished.ID).
+					WillReturnRows(mockRows(cancelled))
+			},
+			expectedStatus: http.StatusOK,
+			expectedStarts: []string{"2024-10-15T18:00:00Z", "2024-10-22T18:00:00Z"},
+			expectedMeta:   pageMeta{Total: 4, Limit: 2, Offset: 1},
+			expectedLinks: `</events?during=2024-10-01%2C2024-10-31&limit=2&offset=0>; rel="first", ` +
+				`</events?during=2024-10-01%2C2024-10-31&limit=2&offset=0>; rel="prev", ` +
+				`</events?during=2024-10-01%2C2024-10-31&limit=2&offset=3>; rel="next", ` +
+				`</events?during=2024-10-01%2C2024-10-31&limit=2&offset=2>; rel="last"`,
+		},
+		{
+			name: "Too many events during the window",
+			path: "/events?during=2024-10-01,2024-10-31",
+			setup: func(mock sqlmock.Sqlmock) {
+				events := make([]models.Model, repository.MaxOccurrenceEvents+1)
+				for i := range events {
+					e := weeklyEvent()
+					e.ID = int64(i + 1)
+					events[i] = e
+				}
+				mock.ExpectQuery("SELECT (.+) FROM events WHERE (.+) ORDER BY id LIMIT \\$3$").
+					WillReturnRows(mockRows(events...))
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Occurrences after a cursor",
+			path:           "/events?during=2024-10-01,2024-10-31&cursor=abc",
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Window longer than a year",
+			path:           "/events?during=2024-01-01,2025-06-01",
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Occurrences without a window",
+			path:           "/events/1/occurrences",
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			tt.setup(mock)
+
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Data map[string][]struct {
+					StartDate     string `json:"startDate"`
+					OriginalStart string `json:"originalStart"`
+				} `json:"data"`
+				Meta pageMeta `json:"meta"`
+			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+			assert.Equal(t, tt.expectedMeta, res.Meta)
+			assert.Equal(t, tt.expectedLinks, w.Header().Get("Link"))
+			var starts []string
+			for _, occurrences := range res.Data {
+				for _, o := range occurrences {
+					starts = append(starts, o.StartDate)
+					assert.Equal(t, o.StartDate, o.OriginalStart)
+				}
+			}
+			assert.Equal(t, tt.expectedStarts, starts)
+		})
+	}
+}
+
+func TestOverrideOccurrence(t *testing.T) {
+	e := weeklyEvent()
+	second := e.StartDate.AddDate(0, 0, 7)
+
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		userID         int64
+		event          func() models.Event
+		setup          func(mock sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			name:  "Cancel an occurrence",
+			path:  "/events/1/occurrences/2024-10-08T18:00:00Z",
+			body:  `{"cancelled": true}`,
+			event: weeklyEvent,
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("INSERT INTO occurrence_overrides (.+) ON CONFLICT").
+					WithArgs(e.ID, second, true, second, second.Add(2*time.Hour)).
+					WillReturnRows(mockRows(models.OccurrenceOverride{ID: 1, EventID: e.ID, OriginalStart: second, Cancelled: true, StartDate: second, EndDate: second.Add(2 * time.Hour)}))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:  "Move an occurrence",
+			path:  "/events/1/occurrences/2024-10-08T20:00:00+02:00",
+			body:  `{"startDate": "2024-10-09T18:00:00Z"}`,
+			event: weeklyEvent,
+			setup: func(mock sqlmock.Sqlmock) {
+				moved := second.AddDate(0, 0, 1)
+				mock.ExpectQuery("INSERT INTO occurrence_overrides").
+					WithArgs(e.ID, sqlmock.AnyArg(), false, moved, moved.Add(2*time.Hour)).
+					WillReturnRows(mockRows(models.OccurrenceOverride{ID: 1, EventID: e.ID, OriginalStart: second, StartDate: moved, EndDate: moved.Add(2 * time.Hour)}))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Moved to end before it starts",
+			path:           "/events/1/occurrences/2024-10-08T18:00:00Z",
+			body:           `{"startDate": "2024-10-09T18:00:00Z", "endDate": "2024-10-09T17:00:00Z"}`,
+			event:          weeklyEvent,
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Moved without a start",
+			path:           "/events/1/occurrences/2024-10-08T18:00:00Z",
+			body:           `{}`,
+			event:          weeklyEvent,
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Not an occurrence",
+			path:           "/events/1/occurrences/2024-10-09T18:00:00Z",
+			body:           `{"cancelled": true}`,
+			event:          weeklyEvent,
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Event doesn't recur",
+			path:           "/events/1/occurrences/2024-10-08T18:00:00Z",
+			body:           `{"cancelled": true}`,
+			event:          testEvent,
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Someone else's event",
+			path:           "/events/1/occurrences/2024-10-08T18:00:00Z",
+			body:           `{"cancelled": true}`,
+			userID:         2,
+			event:          weeklyEvent,
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+				WithArgs(1).
+				WillReturnRows(mockRows(tt.event()))
+			tt.setup(mock)
+
+			userID := tt.userID
+			if userID == 0 {
+				userID = e.UserID
+			}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, tt.path, bytes.NewBufferString(tt.body))
+			app.routes().ServeHTTP(w, authorize(t, app, req, userID))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("Invalid start", func(t *testing.T) {
+		app, mock := newTestApp(t)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/events/1/occurrences/next-tuesday", bytes.NewBufferString(`{"cancelled": true}`))
+		app.routes().ServeHTTP(w, authorize(t, app, req, e.UserID))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRestoreOccurrence(t *testing.T) {
+	e := weeklyEvent()
+	second := e.StartDate.AddDate(0, 0, 7)
+
+	tests := []struct {
+		name           string
+		rowsAffected   int64
+		expectedStatus int
+	}{
+		{"Overridden occurrence", 1, http.StatusOK},
+		{"Occurrence as scheduled", 0, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+				WithArgs(1).
+				WillReturnRows(mockRows(e))
+			mock.ExpectExec("DELETE FROM occurrence_overrides WHERE event_id = \\$1 AND original_start = \\$2").
+				WithArgs(e.ID, second).
+				WillReturnResult(sqlmock.NewResult(0, tt.rowsAffected))
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodDelete, "/events/1/occurrences/2024-10-08T18:00:00Z", nil)
+			app.routes().ServeHTTP(w, authorize(t, app, req, e.UserID))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("Missing event", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+			WithArgs(1).
+			WillReturnError(sql.ErrNoRows)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, "/events/1/occurrences/2024-10-08T18:00:00Z", nil)
+		app.routes().ServeHTTP(w, authorize(t, app, req, e.UserID))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"events-app/data/models"
+	"events-app/data/repository"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// pageMeta describes the page of records a list response holds, under "meta":
+// how many records match the request's filters in all, and which of them the
+// page starts at and holds at most. A full page also has a cursor to the
+// next; see repository.SqlRepo.NextCursor.
+type pageMeta struct {
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextCursor string `json:"nextCursor,omitempty"`
+
+	// Cursor is the one the page was read after, if any
+	Cursor string `json:"-"`
+}
+
+// cursorMeta is the meta of a page read after a cursor. Where it starts
+// isn't known without counting the records before it, which would cost what
+// the cursor saves, so it has no total or offset.
+type cursorMeta struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// readPage reads the limit, offset and cursor query parameters of a list
+// request, with the repository's defaults.
+func readPage(params map[string]string) (pageMeta, error) {
+	limit, err := countParam(params, "limit", repository.DefaultLimit)
+	if err != nil {
+		return pageMeta{}, err
+	}
+	offset, err := countParam(params, "offset", 0)
+	if err != nil {
+		return pageMeta{}, err
+	}
+	return pageMeta{Limit: limit, Offset: offset, Cursor: params["cursor"]}, nil
+}
+
+// finishPage fills in the rest of the page's meta, given the records of m's
+// table matching params that it holds, as a slice or a pointer to one. A page
+// that isn't full is the last one, so its total is known without asking the
+// db, unless it is empty because the offset is past the end. Pages read after
+// a cursor aren't counted.
+func (app *application) finishPage(ctx context.Context, page *pageMeta, m models.Model, params map[string]string, records interface{}) error {
+	val := reflect.Indirect(reflect.ValueOf(records))
+	n := val.Len()
+	if n > 0 && n == page.Limit {
+		cursor, err := app.Repo.NextCursor(val.Index(n-1).Interface().(models.Model), params)
+		if err != nil {
+			return err
+		}
+		page.NextCursor = cursor
+	}
+
+	if page.Cursor != "" {
+		return nil
+	}
+	if n < page.Limit && (n > 0 || page.Offset == 0) {
+		page.Total = page.Offset + n
+		return nil
+	}
+	total, err := app.Repo.CountModelContext(ctx, m, params)
+	if err != nil {
+		return err
+	}
+	page.Total = total
+	return nil
+}
+
+// sendPage responds with a page of records, wrapped as with SendSuccessJSON,
+// along with its meta and Link headers (RFC 8288) to the first, previous,
+// next and last pages. The links keep the request's other query parameters.
+func (app *application) sendPage(w http.ResponseWriter, r *http.Request, page pageMeta, data interface{}, wrap string) error {
+	links := pageLinks(r, page)
+	var meta interface{} = page
+	if page.Cursor != "" {
+		links = cursorLinks(r, page)
+		meta = cursorMeta{Limit: page.Limit, NextCursor: page.NextCursor}
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	jsonRes := successJSON{
+		Status: "success",
+		Data:   map[string]interface{}{wrap: data},
+		Meta:   meta,
+	}
+	return marshalAndSend(w, jsonRes, http.StatusOK)
+}
+
+// cursorLinks returns the Link header values for a page read after a cursor:
+// the first page, and the next if there is one. There is no way back but to
+// start over.
+func cursorLinks(r *http.Request, page pageMeta) []string {
+	link := func(cursor, rel string) string {
+		query := r.URL.Query()
+		query.Set("limit", strconv.Itoa(page.Limit))
+		query.Del("cursor")
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, query.Encode(), rel)
+	}
+
+	links := []string{link("", "first")}
+	if page.NextCursor != "" {
+		links = append(links, link(page.NextCursor, "next"))
+	}
+	return links
+}
+
+// pageLinks returns the Link header values for the pages around page. There is
+// no previous page on the first, and no next page on the last; with a limit of
+// zero there are no pages to go through at all.
+func pageLinks(r *http.Request, page pageMeta) []string {
+	if page.Limit == 0 {
+		return nil
+	}
+	link := func(offset int, rel string) string {
+		query := r.URL.Query()
+		query.Set("limit", strconv.Itoa(page.Limit))
+		query.Set("offset", strconv.Itoa(offset))
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, query.Encode(), rel)
+	}
+
+	last := 0
+	if page.Total > 0 {
+		last = (page.Total - 1) / page.Limit * page.Limit
+	}
+
+	links := []string{link(0, "first")}
+	if page.Offset > 0 {
+		links = append(links, link(max(page.Offset-page.Limit, 0), "prev"))
+	}
+	if page.Offset+page.Limit < page.Total {
+		links = append(links, link(page.Offset+page.Limit, "next"))
+	}
+	return append(links, link(last, "last"))
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"events-app/data/models"
+	"events-app/data/repository"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		page     pageMeta
+		expected []string
+	}{
+		{
+			name: "First page",
+			path: "/events?name_contains=party&limit=10",
+			page: pageMeta{Total: 25, Limit: 10, Offset: 0},
+			expected: []string{
+				`</events?limit=10&name_contains=party&offset=0>; rel="first"`,
+				`</events?limit=10&name_contains=party&offset=10>; rel="next"`,
+				`</events?limit=10&name_contains=party&offset=20>; rel="last"`,
+			},
+		},
+		{
+			name: "Middle page",
+			path: "/events?offset=15",
+			page: pageMeta{Total: 25, Limit: 10, Offset: 15},
+			expected: []string{
+				`</events?limit=10&offset=0>; rel="first"`,
+				`</events?limit=10&offset=5>; rel="prev"`,
+				`</events?limit=10&offset=20>; rel="last"`,
+			},
+		},
+		{
+			name: "Offset past the end",
+			path: "/events?offset=40",
+			page: pageMeta{Total: 25, Limit: 10, Offset: 40},
+			expected: []string{
+				`</events?limit=10&offset=0>; rel="first"`,
+				`</events?limit=10&offset=30>; rel="prev"`,
+				`</events?limit=10&offset=20>; rel="last"`,
+			},
+		},
+		{
+			name: "Nothing to list",
+			path: "/events",
+			page: pageMeta{Total: 0, Limit: 10, Offset: 0},
+			expected: []string{
+				`</events?limit=10&offset=0>; rel="first"`,
+				`</events?limit=10&offset=0>; rel="last"`,
+			},
+		},
+		{
+			name:     "No limit",
+			path:     "/events?limit=0",
+			page:     pageMeta{Total: 25, Limit: 0, Offset: 0},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			assert.Equal(t, tt.expected, pageLinks(r, tt.page))
+		})
+	}
+}
+
+func TestListEventsPage(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		setup          func(mock sqlmock.Sqlmock)
+		expectedStatus int
+		expectedMeta   pageMeta
+		expectedCursor bool
+	}{
+		{
+			name: "A full page is counted",
+			path: "/events?name_contains=Test&limit=2&offset=2",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM events WHERE name LIKE \\$1 ORDER BY id ASC LIMIT \\$2 OFFSET \\$3").
+					WithArgs("%Test%", 2, 2).
+					WillReturnRows(mockRows(testEvent(), testEvent()))
+				mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM events WHERE name LIKE \\$1$").
+					WithArgs("%Test%").
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+			},
+			expectedStatus: http.StatusOK,
+			expectedMeta:   pageMeta{Total: 7, Limit: 2, Offset: 2},
+			expectedCursor: true,
+		},
+		{
+			name: "The last page needs no count",
+			path: "/events?offset=20",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM events ORDER BY id ASC").
+					WithArgs(10, 20).
+					WillReturnRows(mockRows(testEvent()))
+			},
+			expectedStatus: http.StatusOK,
+			expectedMeta:   pageMeta{Total: 21, Limit: 10, Offset: 20},
+		},
+		{
+			name: "Nor does a first page that isn't full",
+			path: "/events",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM events ORDER BY id ASC").
+					WithArgs(10, 0).
+					WillReturnRows(mockRows(testEvent()))
+			},
+			expectedStatus: http.StatusOK,
+			expectedMeta:   pageMeta{Total: 1, Limit: 10, Offset: 0},
+		},
+		{
+			name: "An empty page past the end is counted",
+			path: "/events?offset=50",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM events ORDER BY id ASC").
+					WithArgs(10, 50).
+					WillReturnRows(sqlmock.NewRows(models.GetColumnNames(models.Event{}, false)))
+				mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM events").
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(21))
+			},
+			expectedStatus: http.StatusOK,
+			expectedMeta:   pageMeta{Total: 21, Limit: 10, Offset: 50},
+		},
+		{
+			name:           "Negative limit",
+			path:           "/events?limit=-1",
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Offset that isn't a number",
+			path:           "/events?offset=second",
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			tt.setup(mock)
+
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Meta pageMeta `json:"meta"`
+			}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			assert.Equal(t, tt.expectedCursor, res.Meta.NextCursor != "")
+			res.Meta.NextCursor = ""
+			assert.Equal(t, tt.expectedMeta, res.Meta)
+			assert.Contains(t, w.Header().Get("Link"), `rel="first"`)
+		})
+	}
+}
+
+func TestListEventsCursor(t *testing.T) {
+	last := testEvent()
+	byStart := map[string]string{"sortBy": "-startDate"}
+	minter, _ := newTestApp(t)
+	cursor, err := minter.Repo.NextCursor(last, byStart)
+	assert.NoError(t, err)
+	userCursor, err := minter.Repo.NextCursor(models.User{ID: 1}, map[string]string{})
+	assert.NoError(t, err)
+	otherKey := &repository.SqlRepo{CursorKey: []byte("another-secret")}
+	otherCursor, err := otherKey.NextCursor(last, byStart)
+	assert.NoError(t, err)
+	// What a cursor would be if it were only encoded
+	unsealed := base64.RawURLEncoding.EncodeToString([]byte(`{"s":"-startDate","v":"2000-01-01T00:00:00Z","i":1}`))
+
+	tests := []struct {
+		name           string
+		path           string
+		setup          func(mock sqlmock.Sqlmock)
+		expectedStatus int
+		expectedLinks  string
+	}{
+		{
+			name: "Full page",
+			path: "/events?sortBy=-startDate&limit=2&cursor=" + cursor,
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM events WHERE \\(start_date, id\\) < \\(\\$1, \\$2\\) ORDER BY start_date DESC, id DESC LIMIT \\$3 OFFSET \\$4").
+					WithArgs(last.StartDate.Format(time.RFC3339Nano), last.ID, 2, 0).
+					WillReturnRows(mockRows(testEvent(), testEvent()))
+			},
+			expectedStatus: http.StatusOK,
+			// The page is full, so there may be another after it
+			expectedLinks: `</events?limit=2&sortBy=-startDate>; rel="first", </events?cursor={next}&limit=2&sortBy=-startDate>; rel="next"`,
+		},
+		{
+			name: "Last page",
+			path: "/events?name=Test&sortBy=-startDate&cursor=" + cursor,
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM events WHERE name = \\$1 AND \\(start_date, id\\) < \\(\\$2, \\$3\\)").
+					WithArgs("Test", last.StartDate.Format(time.RFC3339Nano), last.ID, 10, 0).
+					WillReturnRows(mockRows(testEvent()))
+			},
+			expectedStatus: http.StatusOK,
+			expectedLinks:  `</events?limit=10&name=Test&sortBy=-startDate>; rel="first"`,
+		},
+		{
+			name:           "Cursor for another sort order",
+			path:           "/events?sortBy=name&cursor=" + cursor,
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Cursor and offset",
+			path:           "/events?sortBy=-startDate&offset=10&cursor=" + cursor,
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Cursor made for users",
+			path:           "/events?cursor=" + userCursor,
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Cursor made with another key",
+			path:           "/events?sortBy=-startDate&cursor=" + otherCursor,
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Forged cursor",
+			path:           "/events?sortBy=-startDate&cursor=" + unsealed,
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Made up cursor",
+			path:           "/events?cursor=page-3",
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			tt.setup(mock)
+
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			// Pages read after a cursor aren't counted
+			assert.NoError(t, mock.ExpectationsWereMet())
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Meta map[string]interface{} `json:"meta"`
+			}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			assert.NotContains(t, res.Meta, "total")
+			assert.NotContains(t, res.Meta, "offset")
+			next, _ := res.Meta["nextCursor"].(string)
+			assert.Equal(t, strings.ReplaceAll(tt.expectedLinks, "{next}", next), w.Header().Get("Link"))
+
+			// The sort key in a cursor can't be read from it
+			if next != "" {
+				decoded, _ := base64.RawURLEncoding.DecodeString(next)
+				assert.NotContains(t, string(decoded), last.StartDate.Format("2006-01-02"))
+			}
+		})
+	}
+}
+
+func TestListAttendeesPage(t *testing.T) {
+	app, mock := newTestApp(t)
+	mock.ExpectQuery("SELECT (.+) FROM attendees WHERE event_id = \\$1 ORDER BY id ASC LIMIT \\$2 OFFSET \\$3").
+		WithArgs(1, 1, 0).
+		WillReturnRows(mockRows(models.Attendee{ID: 1, EventID: 1, UserID: 1, Status: models.AttendeeConfirmed}))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM attendees WHERE event_id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events/1/attendees?limit=1", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	// The event comes from the path, so it isn't repeated in the links
+	assert.Equal(t,
+		`</events/1/attendees?limit=1&offset=0>; rel="first", </events/1/attendees?limit=1&offset=1>; rel="next", </events/1/attendees?limit=1&offset=2>; rel="last"`,
+		w.Header().Get("Link"))
+}
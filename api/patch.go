@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"events-app/data/models"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+var (
+	errUnsupportedPatch = errors.New("the request body must be a JSON merge patch (" + mergePatchType + ") or a JSON patch (" + jsonPatchType + ")")
+	errMergePatchObject = errors.New("a merge patch must be a JSON object")
+)
+
+// ReadPatch applies the PATCH request's body to dest, a pointer to a model
+// holding the resource's current state. The body is treated as a JSON Merge
+// Patch (RFC 7396) unless its Content-Type says it is a JSON Patch (RFC 6902).
+//
+// It returns the JSON names of the writable fields the patch touches, and only
+// those fields are validated, with the request's context; see
+// models.ValidateModelContext. Read-only fields are left out, so patching them
+// has no effect.
+func (app *application) ReadPatch(w http.ResponseWriter, r *http.Request, dest models.Model) ([]string, error) {
+	mediaType := "application/json"
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(ct); err != nil {
+			return nil, errUnsupportedPatch
+		}
+	}
+
+	maxBytes := 1024 * 1024 // one megabyte
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+	if err != nil {
+		return nil, err
+	}
+
+	original, err := json.Marshal(dest)
+	if err != nil {
+		return nil, err
+	}
+
+	var patched []byte
+	var fields []string
+	switch mediaType {
+	case mergePatchType, "application/json":
+		patched, fields, err = applyMergePatch(original, body)
+	case jsonPatchType:
+		patched, fields, err = applyJSONPatch(original, body)
+	default:
+		return nil, errUnsupportedPatch
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode into a blank model so fields the patch removed end up zeroed
+	val := reflect.ValueOf(dest).Elem()
+	val.Set(reflect.Zero(val.Type()))
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dest); err != nil {
+		return nil, err
+	}
+
+	fields = writableFields(dest, fields)
+	if err := models.ValidateModelFieldsContext(r.Context(), dest, fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// sendPatchError responds to an error returned by ReadPatch.
+func (app *application) sendPatchError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUnsupportedPatch):
+		w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		app.SendErrorJSON(w, http.StatusUnsupportedMediaType, err)
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		app.SendErrorJSON(w, http.StatusConflict, err)
+	case errors.Is(err, models.ErrValidationIncomplete):
+		app.sendRepoError(w, err)
+	default:
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+	}
+}
+
+func applyMergePatch(original, patch []byte) ([]byte, []string, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &members); err != nil || members == nil {
+		return nil, nil, errMergePatchObject
+	}
+
+	patched, err := jsonpatch.MergePatch(original, patch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fields := make([]string, 0, len(members))
+	for f := range members {
+		fields = append(fields, f)
+	}
+	return patched, fields, nil
+}
+
+func applyJSONPatch(original, body []byte) ([]byte, []string, error) {
+	patch, err := jsonpatch.DecodePatch(body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	patched, err := patch.Apply(original)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fields []string
+	for _, op := range patch {
+		var paths []string
+		switch op.Kind() {
+		case "add", "remove", "replace", "copy":
+			p, _ := op.Path()
+			paths = append(paths, p)
+		case "move":
+			p, _ := op.Path()
+			from, _ := op.From()
+			paths = append(paths, p, from)
+		}
+
+		for _, p := range paths {
+			if p == "" {
+				// the whole document was replaced
+				var members map[string]json.RawMessage
+				json.Unmarshal(patched, &members)
+				for f := range members {
+					fields = append(fields, f)
+				}
+				continue
+			}
+			fields = append(fields, topLevelMember(p))
+		}
+	}
+	return patched, fields, nil
+}
+
+// topLevelMember returns the name of the top-level member a JSON Pointer refers
+// to, or to something inside of, e.g. "/tags/0" gives "tags".
+func topLevelMember(pointer string) string {
+	member, _, _ := strings.Cut(strings.TrimPrefix(pointer, "/"), "/")
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(member)
+}
+
+// writableFields filters fields, given by their JSON names, down to the model's
+// writable ones. They are returned in the order the model declares them.
+func writableFields(m models.Model, fields []string) []string {
+	requested := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		requested[f] = true
+	}
+
+	jsonMap := models.MapJsonTagsToDB(m)
+	writable := make(map[string]bool)
+	for _, c := range models.GetColumnNames(m, true) {
+		writable[c] = true
+	}
+
+	typ := reflect.TypeOf(m).Elem()
+	filtered := make([]string, 0, len(fields))
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i).Tag.Get("json")
+		if requested[f] && writable[jsonMap[f]] {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"events-app/data/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchEvent(t *testing.T) {
+	expectUpdate := func(set string, args ...driver.Value) func(mock sqlmock.Sqlmock) {
+		return func(mock sqlmock.Sqlmock) {
+			mock.ExpectPrepare("UPDATE events SET " + set + ", version = version").
+				ExpectExec().
+				WithArgs(args...).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+				WithArgs(1).
+				WillReturnRows(mockRows(testEvent()))
+		}
+	}
+	// A change of capacity is written along with handing the seats it frees
+	// up, if any, to the waitlist; promoted is how many there are.
+	expectResize := func(set string, capacity, confirmed int, promoted sql.NullInt64, args ...driver.Value) func(mock sqlmock.Sqlmock) {
+		return func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectPrepare("UPDATE events SET " + set + ", version = version").
+				ExpectExec().
+				WithArgs(args...).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("SELECT max_attendees FROM events WHERE id = \\$1 FOR UPDATE").
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"max_attendees"}).AddRow(capacity))
+			if capacity > 0 {
+				mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM attendees").
+					WithArgs(1, models.AttendeeConfirmed).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(confirmed))
+			}
+			if capacity == 0 || confirmed < capacity {
+				mock.ExpectExec("UPDATE attendees SET status = \\$1 WHERE id IN").
+					WithArgs(models.AttendeeConfirmed, 1, models.AttendeeWaitlisted, promoted).
+					WillReturnResult(sqlmock.NewResult(0, promoted.Int64))
+			}
+			mock.ExpectCommit()
+			mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+				WithArgs(1).
+				WillReturnRows(mockRows(testEvent()))
+		}
+	}
+
+	tests := []struct {
+		name           string
+		contentType    string
+		body           string
+		setup          func(mock sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			name:           "Merge patch writes only the supplied fields",
+			contentType:    "application/merge-patch+json",
+			body:           `{"name": "A brand new name", "maxAttendees": 10}`,
+			setup:          expectResize("name = \\$1, max_attendees = \\$2", 10, 10, sql.NullInt64{}, "A brand new name", 10, 1, 3),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Plain JSON is a merge patch",
+			contentType:    "application/json",
+			body:           `{"description": "Moved to the town hall"}`,
+			setup:          expectUpdate("description = \\$1", "Moved to the town hall", 1, 3),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Merge patch null clears a field",
+			contentType:    "application/merge-patch+json",
+			body:           `{"maxAttendees": null}`,
+			setup:          expectResize("max_attendees = \\$1", 0, 0, sql.NullInt64{}, 0, 1, 3),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Raising the capacity seats the waitlist",
+			contentType:    "application/merge-patch+json",
+			body:           `{"maxAttendees": 52}`,
+			setup:          expectResize("max_attendees = \\$1", 52, 50, sql.NullInt64{Int64: 2, Valid: true}, 52, 1, 3),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Merge patch clearing a required field",
+			contentType:    "application/merge-patch+json",
+			body:           `{"description": null}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Only supplied fields are validated",
+			contentType:    "application/merge-patch+json",
+			body:           `{"name": "short"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Moving the start past the end",
+			contentType:    "application/merge-patch+json",
+			body:           `{"startDate": "` + testEvent().EndDate.Add(time.Hour).Format(time.RFC3339) + `"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Merge patch must be an object",
+			contentType:    "application/merge-patch+json",
+			body:           `["name"]`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown field",
+			contentType:    "application/merge-patch+json",
+			body:           `{"colour": "red"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "Read-only fields are ignored",
+			contentType: "application/merge-patch+json",
+			body:        `{"createdAt": "2000-01-01T00:00:00Z"}`,
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+					WithArgs(1).
+					WillReturnRows(mockRows(testEvent()))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:        "JSON patch",
+			contentType: "application/json-patch+json",
+			body: `[
+				{"op": "test", "path": "/name", "value": "Test Event"},
+				{"op": "replace", "path": "/name", "value": "A brand new name"},
+				{"op": "remove", "path": "/maxAttendees"}
+			]`,
+			setup:          expectResize("name = \\$1, max_attendees = \\$2", 0, 0, sql.NullInt64{}, "A brand new name", 0, 1, 3),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "JSON patch failed test",
+			contentType:    "application/json-patch+json",
+			body:           `[{"op": "test", "path": "/name", "value": "Another Event"}]`,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "JSON patch on a missing path",
+			contentType:    "application/json-patch+json",
+			body:           `[{"op": "replace", "path": "/colour", "value": "red"}]`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unsupported content type",
+			contentType:    "text/plain",
+			body:           `name=A brand new name`,
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+				WithArgs(1).
+				WillReturnRows(mockRows(testEvent()))
+			if tt.setup != nil {
+				tt.setup(mock)
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPatch, "/events/1", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPatchUserPassword(t *testing.T) {
+	app, mock := newTestApp(t)
+	user := testUser(t)
+
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(mockRows(user))
+	mock.ExpectPrepare("UPDATE users SET password = \\$1 WHERE id = \\$2").
+		ExpectExec().
+		WithArgs(hashOf("a new password"), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(mockRows(user))
+
+	w := httptest.NewRecorder()
+	body := bytes.NewBufferString(`{"password": "a new password"}`)
+	req := httptest.NewRequest(http.MethodPatch, "/users/1", body)
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	app.routes().ServeHTTP(w, authorize(t, app, req, 1))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTopLevelMember(t *testing.T) {
+	assert.Equal(t, "name", topLevelMember("/name"))
+	assert.Equal(t, "tags", topLevelMember("/tags/0"))
+	assert.Equal(t, "a/b~c", topLevelMember("/a~1b~0c"))
+}
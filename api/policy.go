@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"events-app/data/models"
+	"net/http"
+)
+
+var errForbidden = errors.New("you do not have permission to perform this action")
+
+// actor is the authenticated user making a request.
+type actor struct {
+	ID   int64
+	Role string
+}
+
+func (a actor) isAdmin() bool {
+	return a.Role == models.RoleAdmin
+}
+
+// A policy decides whether an actor may perform some action. Policies are built
+// around the resource being acted on, e.g. canModifyEvent(event).
+type policy func(a actor) bool
+
+// adminOnly allows admins and nobody else.
+func adminOnly(a actor) bool {
+	return a.isAdmin()
+}
+
+// canModifyEvent allows the event's owner and admins to change or delete it.
+func canModifyEvent(e models.Event) policy {
+	return func(a actor) bool {
+		return a.isAdmin() || a.ID == e.UserID
+	}
+}
+
+// canModifyUser allows users to change or delete their own account, and admins
+// to change or delete anyone's.
+func canModifyUser(u models.User) policy {
+	return func(a actor) bool {
+		return a.isAdmin() || a.ID == u.ID
+	}
+}
+
+// authorize checks the request's actor against the policy, and responds with a
+// 403 if they are not allowed. Handlers must call it before making changes
+// through the repo and stop if it returns false.
+func (app *application) authorize(w http.ResponseWriter, r *http.Request, p policy) bool {
+	a, ok := actorFromContext(r.Context())
+	if !ok || !p(a) {
+		app.SendErrorJSON(w, http.StatusForbidden, errForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"events-app/data/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicies(t *testing.T) {
+	owner := actor{ID: 1, Role: models.RoleUser}
+	stranger := actor{ID: 2, Role: models.RoleUser}
+	admin := actor{ID: 3, Role: models.RoleAdmin}
+
+	event := models.Event{ID: 10, UserID: owner.ID}
+	user := models.User{ID: owner.ID}
+
+	tests := []struct {
+		name     string
+		policy   policy
+		actor    actor
+		expected bool
+	}{
+		{"Owner may modify event", canModifyEvent(event), owner, true},
+		{"Stranger may not modify event", canModifyEvent(event), stranger, false},
+		{"Admin may modify event", canModifyEvent(event), admin, true},
+		{"User may modify themselves", canModifyUser(user), owner, true},
+		{"Stranger may not modify user", canModifyUser(user), stranger, false},
+		{"Admin may modify user", canModifyUser(user), admin, true},
+		{"Admin passes adminOnly", adminOnly, admin, true},
+		{"User fails adminOnly", adminOnly, owner, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.policy(tt.actor))
+		})
+	}
+}
+
+func TestEventMutationRequiresOwnership(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+	}{
+		{"PUT", http.MethodPut, `{"name": "A new name", "description": "A new description", "startDate": "2030-01-01T00:00:00Z"}`},
+		{"PATCH", http.MethodPatch, `{"name": "A new name"}`},
+		{"DELETE", http.MethodDelete, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+				WithArgs(1).
+				WillReturnRows(mockRows(testEvent()))
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/events/1", bytes.NewBufferString(tt.body))
+			app.routes().ServeHTTP(w, authorize(t, app, req, 2))
+
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			// nothing beyond the lookup may reach the db
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("Admin may delete any event", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+			WithArgs(1).
+			WillReturnRows(mockRows(testEvent()))
+		mock.ExpectPrepare("DELETE FROM events WHERE id = \\$1 AND version = \\$2").
+			ExpectExec().
+			WithArgs(1, 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, "/events/1", nil)
+		app.routes().ServeHTTP(w, authorizeAs(t, app, req, actor{ID: 99, Role: models.RoleAdmin}))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
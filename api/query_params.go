@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// queryParamsFromURL flattens a request's URL query into the map expected by
+// the repository's query builder (e.g. ?name_contains=party&limit=20). Each
+// key may only be supplied once; a repeated key such as two startDate_gte
+// values is rejected rather than letting one of them silently win.
+func queryParamsFromURL(values url.Values) (map[string]string, error) {
+	params := make(map[string]string, len(values))
+	var repeated []string
+
+	for key, vals := range values {
+		if len(vals) > 1 {
+			repeated = append(repeated, key)
+			continue
+		}
+		params[key] = vals[0]
+	}
+
+	if len(repeated) > 0 {
+		// map iteration order is random; keep the message deterministic
+		sort.Strings(repeated)
+		return nil, fmt.Errorf("query parameters may only be supplied once, got repeated: %v", repeated)
+	}
+
+	return params, nil
+}
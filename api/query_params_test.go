@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryParamsFromURL(t *testing.T) {
+	tests := []struct {
+		name           string
+		rawQuery       string
+		expectedParams map[string]string
+		expectedError  string
+	}{
+		{
+			name:           "No params",
+			rawQuery:       "",
+			expectedParams: map[string]string{},
+		},
+		{
+			name:     "Filters, sorting and pagination",
+			rawQuery: "name_contains=party&maxAttendees_gte=10&sortBy=-startDate&limit=20&offset=40",
+			expectedParams: map[string]string{
+				"name_contains":    "party",
+				"maxAttendees_gte": "10",
+				"sortBy":           "-startDate",
+				"limit":            "20",
+				"offset":           "40",
+			},
+		},
+		{
+			name:           "anyOf list stays a single value",
+			rawQuery:       "name_anyOf=Tom,Dick,Harry",
+			expectedParams: map[string]string{"name_anyOf": "Tom,Dick,Harry"},
+		},
+		{
+			name:          "Repeated key",
+			rawQuery:      "startDate_gte=2024-01-01&startDate_gte=2024-06-01",
+			expectedError: "query parameters may only be supplied once, got repeated: [startDate_gte]",
+		},
+		{
+			name:          "Several repeated keys",
+			rawQuery:      "limit=1&limit=2&name=a&name=b",
+			expectedError: "query parameters may only be supplied once, got repeated: [limit name]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.rawQuery)
+			assert.NoError(t, err)
+
+			params, err := queryParamsFromURL(values)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				assert.Nil(t, params)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedParams, params)
+			}
+		})
+	}
+}
+
+func TestListEvents(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		setup          func(mock sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			name: "Filtered query",
+			path: "/events?maxAttendees_gte=10&limit=5",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM events WHERE max_attendees >= \\$1 ORDER BY id ASC LIMIT \\$2 OFFSET \\$3").
+					WithArgs(10, 5, 0).
+					WillReturnRows(mockRows(testEvent()))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Repeated key",
+			path:           "/events?startDate_gte=2024-01-01&startDate_gte=2024-06-01",
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Unescaped plus in an offset",
+			path: "/events?startDate_gte=2024-06-01T10:00:00+02:00",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM events WHERE start_date >= \\$1").
+					WithArgs("2024-06-01T10:00:00+02:00", 10, 0).
+					WillReturnRows(mockRows(testEvent()))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Time without an offset in the requested zone",
+			path: "/events?startDate_gte=2024-06-01&tz=America/New_York",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM events WHERE start_date >= \\$1").
+					WithArgs("2024-06-01T00:00:00-04:00", 10, 0).
+					WillReturnRows(mockRows(testEvent()))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Invalid time",
+			path:           "/events?startDate_gte=June",
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown time zone",
+			path:           "/events?tz=Mars/Olympus_Mons",
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown field",
+			path:           "/events?noSuchThing=1",
+			setup:          func(mock sqlmock.Sqlmock) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			tt.setup(mock)
+
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
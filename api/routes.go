@@ -0,0 +1,43 @@
+package main
+
+import "net/http"
+
+// routes registers every endpoint on a new ServeMux and returns it as the
+// server's root handler. Method and wildcard matching rely on the Go 1.22
+// routing patterns.
+func (app *application) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /auth/login", app.login)
+	mux.HandleFunc("POST /auth/refresh", app.refresh)
+	mux.HandleFunc("POST /auth/logout", app.logout)
+
+	mux.HandleFunc("GET /events", app.listEvents)
+	mux.HandleFunc("POST /events", app.requireAuth(app.createEvent))
+	mux.HandleFunc("GET /events/export.csv", app.exportEvents)
+	mux.HandleFunc("POST /events/import", app.requireAuth(app.importEvents))
+	mux.HandleFunc("POST /events/import.csv", app.requireAuth(app.importEventsCSV))
+	mux.HandleFunc("GET /events/{id}", app.authenticate(app.getEvent))
+	mux.HandleFunc("PUT /events/{id}", app.requireAuth(app.replaceEvent))
+	mux.HandleFunc("PATCH /events/{id}", app.requireAuth(app.patchEvent))
+	mux.HandleFunc("DELETE /events/{id}", app.requireAuth(app.deleteEvent))
+	mux.HandleFunc("GET /events/{id}/occurrences", app.listOccurrences)
+	mux.HandleFunc("PUT /events/{id}/occurrences/{start}", app.requireAuth(app.overrideOccurrence))
+	mux.HandleFunc("DELETE /events/{id}/occurrences/{start}", app.requireAuth(app.restoreOccurrence))
+	mux.HandleFunc("GET /events/{id}/attendees", app.listAttendees)
+	mux.HandleFunc("POST /events/{id}/rsvp", app.requireAuth(app.rsvp))
+	mux.HandleFunc("DELETE /events/{id}/rsvp", app.requireAuth(app.cancelRSVP))
+
+	mux.HandleFunc("GET /users", app.listUsers)
+	mux.HandleFunc("POST /users", app.createUser)
+	mux.HandleFunc("GET /users/{id}", app.getUser)
+	mux.HandleFunc("PUT /users/{id}", app.requireAuth(app.replaceUser))
+	mux.HandleFunc("PATCH /users/{id}", app.requireAuth(app.patchUser))
+	mux.HandleFunc("DELETE /users/{id}", app.requireAuth(app.deleteUser))
+	mux.HandleFunc("GET /users/{id}/calendar.ics", app.userCalendar)
+	mux.HandleFunc("POST /users/{id}/calendar/token", app.requireAuth(app.createCalendarToken))
+
+	mux.HandleFunc("GET /debug/vars", app.requireAuth(app.debugVars))
+
+	return mux
+}
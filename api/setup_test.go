@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql/driver"
+	"events-app/data/models"
+	"events-app/data/repository"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newTestApp returns an application backed by a SqlRepo whose connection is
+// mocked with sqlmock, so handlers can be exercised through the router without
+// a real database.
+func newTestApp(t *testing.T) (*application, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	app := &application{
+		JWTSecret: "test-secret",
+		Repo:      &repository.SqlRepo{DB: db, CursorKey: []byte("test-secret")},
+	}
+	return app, mock
+}
+
+// authorize adds a bearer access token for the given user to the request.
+func authorize(t *testing.T, app *application, r *http.Request, userID int64) *http.Request {
+	return authorizeAs(t, app, r, actor{ID: userID, Role: models.RoleUser})
+}
+
+// authorizeAs adds a bearer access token for the given actor to the request.
+func authorizeAs(t *testing.T, app *application, r *http.Request, a actor) *http.Request {
+	token, _, err := app.newAccessToken(a)
+	if err != nil {
+		t.Fatalf("could not create access token: %s", err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+// mockRows builds sqlmock rows for the given models, with columns in the same
+// order the repository selects them.
+func mockRows(ms ...models.Model) *sqlmock.Rows {
+	rows := sqlmock.NewRows(models.GetColumnNames(ms[0], false))
+	for _, m := range ms {
+		val := reflect.ValueOf(m)
+		vals := make([]driver.Value, val.NumField())
+		for i := 0; i < val.NumField(); i++ {
+			vals[i] = val.Field(i).Interface()
+		}
+		rows.AddRow(vals...)
+	}
+	return rows
+}
+
+// expectOwner expects validation to look up the owner of an event, and finds
+// them if exists is set.
+func expectOwner(mock sqlmock.Sqlmock, id int64, exists bool) {
+	rows := sqlmock.NewRows(models.GetColumnNames(models.User{}, false))
+	if exists {
+		rows = mockRows(models.User{ID: id, Email: "hello@example.com", Role: models.RoleUser})
+	}
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1").
+		WithArgs(id).
+		WillReturnRows(rows)
+}
+
+// hashOf matches a query argument that is the bcrypt hash of a password.
+type hashOf string
+
+func (h hashOf) Match(v driver.Value) bool {
+	hash, ok := v.(string)
+	return ok && models.User{Password: hash}.PasswordMatches(string(h))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"events-app/data/models"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// timeZoneHeader lets clients ask for times in their own zone without adding
+// the tz query parameter to every URL.
+const timeZoneHeader = "Time-Zone"
+
+// requestLocation returns the zone the caller wants times rendered in, named
+// by the tz query parameter or else the Time-Zone header, e.g.
+// ?tz=America/New_York. It returns nil if they didn't ask for one.
+func requestLocation(r *http.Request) (*time.Location, error) {
+	name := r.URL.Query().Get("tz")
+	if name == "" {
+		name = r.Header.Get(timeZoneHeader)
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	// LoadLocation takes "Local" to mean the server's own zone
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return nil, fmt.Errorf("invalid time zone: %s", name)
+	}
+	return loc, nil
+}
+
+// readLocation is requestLocation for handlers; it responds with an error if
+// the zone asked for doesn't exist.
+func (app *application) readLocation(w http.ResponseWriter, r *http.Request) (*time.Location, bool) {
+	loc, err := requestLocation(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	return loc, true
+}
+
+// eventIn returns the event with its times in loc, or as it is if loc is nil.
+func eventIn(event models.Event, loc *time.Location) models.Event {
+	if loc == nil {
+		return event
+	}
+	return event.In(loc)
+}
+
+// occurrenceIn is eventIn for occurrences.
+func occurrenceIn(o models.Occurrence, loc *time.Location) models.Occurrence {
+	if loc == nil {
+		return o
+	}
+	return o.In(loc)
+}
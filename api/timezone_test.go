@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventTimeZones(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		header         string
+		expectedStatus int
+		expectedOffset string
+	}{
+		{"As stored", "/events/1", "", http.StatusOK, "Z"},
+		{"Query parameter", "/events/1?tz=Asia/Tokyo", "", http.StatusOK, "+09:00"},
+		{"Header", "/events/1", "Asia/Kolkata", http.StatusOK, "+05:30"},
+		{"Query parameter wins", "/events/1?tz=Asia/Tokyo", "Asia/Kolkata", http.StatusOK, "+09:00"},
+		{"Unknown zone", "/events/1?tz=Mars/Olympus_Mons", "", http.StatusBadRequest, ""},
+		{"Server's zone", "/events/1", "Local", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			if tt.expectedStatus == http.StatusOK {
+				mock.ExpectQuery("SELECT (.+) FROM events WHERE id = \\$1").
+					WithArgs(1).
+					WillReturnRows(mockRows(testEvent()))
+				expectWaitlistStatus(mock, 0, 0, 0)
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(timeZoneHeader, tt.header)
+			}
+			app.routes().ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Data struct {
+					Event struct {
+						StartDate string `json:"startDate"`
+					} `json:"event"`
+				} `json:"data"`
+			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+			assert.True(t, strings.HasSuffix(res.Data.Event.StartDate, tt.expectedOffset), res.Data.Event.StartDate)
+		})
+	}
+
+	t.Run("Filters use the header's zone", func(t *testing.T) {
+		app, mock := newTestApp(t)
+		mock.ExpectQuery("SELECT (.+) FROM events WHERE start_date >= \\$1").
+			WithArgs("2024-06-01T00:00:00+02:00", 10, 0).
+			WillReturnRows(mockRows(testEvent()))
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/events?startDate_gte=2024-06-01", nil)
+		req.Header.Set(timeZoneHeader, "Europe/Berlin")
+		app.routes().ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"events-app/data/models"
+	"net/http"
+	"slices"
+)
+
+func (app *application) listUsers(w http.ResponseWriter, r *http.Request) {
+	params, err := queryParamsFromURL(r.URL.Query())
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page, err := readPage(params)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	users, err := app.Repo.QueryModelContext(r.Context(), models.User{}, params)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+	if err := app.finishPage(r.Context(), &page, models.User{}, params, users); err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	app.sendPage(w, r, page, users, "users")
+}
+
+func (app *application) createUser(w http.ResponseWriter, r *http.Request) {
+	var user models.User
+	if err := app.ReadJSON(w, r, &user, true); err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := user.HashPassword(); err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	id, err := app.Repo.CreateContext(r.Context(), user)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	created, err := app.Repo.GetUserByIDContext(r.Context(), id)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	app.SendSuccessJSON(w, http.StatusCreated, created, "user")
+}
+
+func (app *application) getUser(w http.ResponseWriter, r *http.Request) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := app.Repo.GetUserByIDContext(r.Context(), id)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	app.SendSuccessJSON(w, http.StatusOK, user, "user")
+}
+
+// replaceUser handles PUT requests; the payload must describe the whole user.
+func (app *application) replaceUser(w http.ResponseWriter, r *http.Request) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	existing, err := app.Repo.GetUserByIDContext(r.Context(), id)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	if !app.authorize(w, r, canModifyUser(existing)) {
+		return
+	}
+
+	var user models.User
+	if err := app.ReadJSON(w, r, &user, true); err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := user.HashPassword(); err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+	user.ID = existing.ID
+
+	app.saveUser(w, r, user, nil)
+}
+
+// patchUser handles PATCH requests; only the fields the patch touches are
+// written. See ReadPatch for the accepted formats.
+func (app *application) patchUser(w http.ResponseWriter, r *http.Request) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := app.Repo.GetUserByIDContext(r.Context(), id)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	if !app.authorize(w, r, canModifyUser(user)) {
+		return
+	}
+
+	fields, err := app.ReadPatch(w, r, &user)
+	if err != nil {
+		app.sendPatchError(w, err)
+		return
+	}
+	// The user was read with its stored hash, so the password only needs
+	// hashing when the patch sets a new one
+	if slices.Contains(fields, "password") {
+		if err := user.HashPassword(); err != nil {
+			app.sendRepoError(w, err)
+			return
+		}
+	}
+	user.ID = id
+
+	app.saveUser(w, r, user, fields)
+}
+
+// saveUser persists an updated user and responds with its new state. Only the
+// given fields are written, or all of them if fields is nil.
+func (app *application) saveUser(w http.ResponseWriter, r *http.Request, user models.User, fields []string) {
+	var err error
+	if fields == nil {
+		err = app.Repo.UpdateContext(r.Context(), user)
+	} else {
+		err = app.Repo.UpdateColumnsContext(r.Context(), user, fields)
+	}
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	updated, err := app.Repo.GetUserByIDContext(r.Context(), user.ID)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	app.SendSuccessJSON(w, http.StatusOK, updated, "user")
+}
+
+func (app *application) deleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.SendErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := app.Repo.GetUserByIDContext(r.Context(), id)
+	if err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	if !app.authorize(w, r, canModifyUser(user)) {
+		return
+	}
+
+	if err := app.Repo.DeleteContext(r.Context(), user); err != nil {
+		app.sendRepoError(w, err)
+		return
+	}
+
+	app.SendSuccessJSON(w, http.StatusOK, nil)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"events-app/data/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateUser(t *testing.T) {
+	tests := []struct {
+		name            string
+		password        string
+		insertErr       error
+		expectedStatus  int
+		expectedDetails map[string]string
+	}{
+		{
+			name:           "Created",
+			password:       "password",
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			// Hashed like any other password rather than stored as the hash
+			name:           "Password shaped like a hash",
+			password:       "$2a$04$0u4mKsG1dQ5MlRFWYpDv3.ZWrxBnNq0Xz8d1pOGrVd8kbl6Wf5z9a",
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:     "Email taken",
+			password: "password",
+			insertErr: &pgconn.PgError{
+				Code:   pgerrcode.UniqueViolation,
+				Detail: "Key (email)=(hello@example.com) already exists.",
+			},
+			expectedStatus:  http.StatusConflict,
+			expectedDetails: map[string]string{"email": "is already taken"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newTestApp(t)
+			insert := mock.ExpectPrepare("INSERT INTO users").
+				ExpectQuery().
+				WithArgs("hello@example.com", hashOf(tt.password))
+			if tt.insertErr != nil {
+				insert.WillReturnError(tt.insertErr)
+			} else {
+				insert.WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1").
+					WithArgs(1).
+					WillReturnRows(mockRows(testUser(t)))
+			}
+
+			w := httptest.NewRecorder()
+			body, err := json.Marshal(map[string]string{"email": "hello@example.com", "password": tt.password})
+			assert.NoError(t, err)
+			req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewReader(body))
+			app.routes().ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			var response errorJSON
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			assert.Equal(t, tt.expectedDetails, response.Details)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCreateUserValidation(t *testing.T) {
+	app, mock := newTestApp(t)
+
+	w := httptest.NewRecorder()
+	body := bytes.NewBufferString(`{"email": "hello@example", "password": "pass"}`)
+	req := httptest.NewRequest(http.MethodPost, "/users", body)
+	app.routes().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var response errorJSON
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, models.ValidationErrors{
+		{Field: "email", Rule: "email", Message: "email must be a valid email address"},
+		{Field: "password", Rule: "min", Param: "6", Message: "password must be at least 6 characters long"},
+	}, response.Errors)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateUserPasswordTooLong(t *testing.T) {
+	app, mock := newTestApp(t)
+
+	// 25 characters, but 75 bytes, which bcrypt won't hash
+	w := httptest.NewRecorder()
+	body := bytes.NewBufferString(`{"email": "hello@example.com", "password": "` + strings.Repeat("€", 25) + `"}`)
+	req := httptest.NewRequest(http.MethodPost, "/users", body)
+	app.routes().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var response errorJSON
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, models.ValidationErrors{
+		{Field: "password", Rule: "maxbytes", Param: "72", Message: "password must be at most 72 bytes long"},
+	}, response.Errors)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListUsersWriteOnlyFields(t *testing.T) {
+	// Filtering or sorting on a password hash would give it away a bit at a
+	// time, so it is refused like a field users don't have
+	for _, query := range []string{
+		"password=secret",
+		"password_gte=$2a$10$",
+		"password_contains=abc",
+		"password_anyOf=a,b",
+		"sortBy=password",
+		"sortBy=-password",
+	} {
+		t.Run(query, func(t *testing.T) {
+			app, mock := newTestApp(t)
+
+			w := httptest.NewRecorder()
+			app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users?"+url.PathEscape(query), nil))
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			var response errorJSON
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			assert.Contains(t, response.Message, "password")
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
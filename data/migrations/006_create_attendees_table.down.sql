@@ -0,0 +1 @@
+DROP TABLE IF EXISTS attendees;
\ No newline at end of file
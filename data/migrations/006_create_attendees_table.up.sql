@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS attendees (
+    id SERIAL PRIMARY KEY,
+    event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status TEXT NOT NULL CHECK (status IN ('confirmed', 'cancelled')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (event_id, user_id)
+);
\ No newline at end of file
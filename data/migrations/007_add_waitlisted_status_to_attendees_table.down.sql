@@ -0,0 +1,3 @@
+UPDATE attendees SET status = 'cancelled' WHERE status = 'waitlisted';
+ALTER TABLE attendees DROP CONSTRAINT attendees_status_check;
+ALTER TABLE attendees ADD CONSTRAINT attendees_status_check CHECK (status IN ('confirmed', 'cancelled'));
\ No newline at end of file
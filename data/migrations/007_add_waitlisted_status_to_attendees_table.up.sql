@@ -0,0 +1,2 @@
+ALTER TABLE attendees DROP CONSTRAINT attendees_status_check;
+ALTER TABLE attendees ADD CONSTRAINT attendees_status_check CHECK (status IN ('confirmed', 'waitlisted', 'cancelled'));
\ No newline at end of file
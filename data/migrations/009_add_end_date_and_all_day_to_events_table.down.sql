@@ -0,0 +1,2 @@
+ALTER TABLE events DROP COLUMN all_day;
+ALTER TABLE events DROP COLUMN end_date;
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE events ADD COLUMN end_date TIMESTAMP;
+UPDATE events SET end_date = start_date + INTERVAL '1 hour';
+ALTER TABLE events ALTER COLUMN end_date SET NOT NULL;
+ALTER TABLE events ADD CONSTRAINT events_end_date_check CHECK (end_date >= start_date);
+ALTER TABLE events ADD COLUMN all_day BOOLEAN NOT NULL DEFAULT FALSE;
\ No newline at end of file
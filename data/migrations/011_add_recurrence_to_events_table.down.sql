@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS occurrence_overrides;
+ALTER TABLE events DROP COLUMN exdates;
+ALTER TABLE events DROP COLUMN rrule;
\ No newline at end of file
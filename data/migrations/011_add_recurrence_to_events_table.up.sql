@@ -0,0 +1,13 @@
+ALTER TABLE events ADD COLUMN rrule TEXT NOT NULL DEFAULT '';
+ALTER TABLE events ADD COLUMN exdates TIMESTAMPTZ[] NOT NULL DEFAULT '{}';
+CREATE TABLE IF NOT EXISTS occurrence_overrides (
+    id SERIAL PRIMARY KEY,
+    event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+    original_start TIMESTAMPTZ NOT NULL,
+    cancelled BOOLEAN NOT NULL DEFAULT FALSE,
+    start_date TIMESTAMPTZ NOT NULL,
+    end_date TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (event_id, original_start),
+    CONSTRAINT occurrence_overrides_end_date_check CHECK (end_date >= start_date)
+);
\ No newline at end of file
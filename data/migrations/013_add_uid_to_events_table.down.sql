@@ -0,0 +1 @@
+ALTER TABLE events DROP COLUMN uid;
\ No newline at end of file
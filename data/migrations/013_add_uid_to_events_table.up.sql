@@ -0,0 +1,4 @@
+ALTER TABLE events ADD COLUMN uid TEXT NOT NULL DEFAULT '';
+
+-- Imported events are matched by UID against the importer's own events
+CREATE UNIQUE INDEX events_user_id_uid_key ON events (user_id, uid) WHERE uid <> '';
\ No newline at end of file
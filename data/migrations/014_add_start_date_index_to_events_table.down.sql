@@ -0,0 +1 @@
+DROP INDEX events_start_date_id_idx;
\ No newline at end of file
@@ -0,0 +1,2 @@
+-- Events are listed by start date, and paged through with cursors on it
+CREATE INDEX events_start_date_id_idx ON events (start_date, id);
\ No newline at end of file
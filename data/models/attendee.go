@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+const (
+	AttendeeConfirmed  = "confirmed"
+	AttendeeWaitlisted = "waitlisted"
+	AttendeeCancelled  = "cancelled"
+)
+
+// Attendee records a user's RSVP to an event. Once an event is full, RSVPs are
+// waitlisted in order of CreatedAt. Cancelling an RSVP keeps the row around
+// with a cancelled status.
+type Attendee struct {
+	ID        int64     `json:"id" db:"id" readOnly:"true"`
+	EventID   int64     `json:"eventId" db:"event_id"`
+	UserID    int64     `json:"userId" db:"user_id"`
+	Status    string    `validate:"oneof=confirmed waitlisted cancelled" json:"status" db:"status"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at" readOnly:"true"`
+}
+
+func (Attendee) TableName() string {
+	return "attendees"
+}
+
+func (Attendee) EmptySlice() interface{} {
+	return &[]Attendee{}
+}
+
+func (a Attendee) GetID() int64 {
+	return a.ID
+}
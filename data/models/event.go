@@ -1,15 +1,42 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultEventDuration is how long an event lasts if it isn't given an end
+	DefaultEventDuration = time.Hour
+	// AllDayEventDuration is how long an all-day event lasts if it isn't given
+	// an end
+	AllDayEventDuration = 24 * time.Hour
+)
 
 type Event struct {
-	ID           int64     `json:"id" db:"id" readOnly:"true"`
-	UserID       int64     `json:"userId" db:"user_id"`
-	Name         string    `validate:"required,min=8,max=100" json:"name" db:"name"`
-	Description  string    `validate:"required,min=8,max=500" json:"description" db:"description"`
-	StartDate    time.Time `validate:"required" json:"startDate" db:"start_date"`
+	ID          int64     `json:"id" db:"id" readOnly:"true"`
+	UserID      int64     `validate:"required,existinguser" json:"userId" db:"user_id"`
+	Name        string    `validate:"required,min=8,max=100" json:"name" db:"name"`
+	Description string    `validate:"required,min=8,max=500" json:"description" db:"description"`
+	StartDate   time.Time `validate:"required,futuredate" json:"startDate" db:"start_date"`
+	// EndDate defaults to an hour after StartDate, or a day for all-day events
+	EndDate time.Time `json:"endDate" db:"end_date"`
+	AllDay  bool      `json:"allDay" db:"all_day"`
+	// Timezone is the IANA name of the zone the event takes place in,
+	// defaulting to UTC
+	Timezone string `validate:"timezone" json:"timezone" db:"timezone"`
+	// RRule makes the event recur, e.g. FREQ=WEEKLY;COUNT=52. It is an RFC 5545
+	// RRULE without DTSTART; the series starts at StartDate, and every
+	// occurrence lasts as long as the first
+	RRule string `validate:"rrule" json:"rrule" db:"rrule"`
+	// ExDates are the starts of occurrences left out of the series
+	ExDates TimeList `json:"exdates" db:"exdates"`
+	// UID is the event's iCalendar UID if it was imported from another
+	// calendar, so importing it again is recognised as a duplicate
+	UID          string    `validate:"max=255" json:"uid" db:"uid"`
 	CreatedAt    time.Time `json:"createdAt" db:"created_at" readOnly:"true"`
-	MaxAttendees int       `json:"maxAttendees" db:"max_attendees"`
+	MaxAttendees int       `validate:"capacity" json:"maxAttendees" db:"max_attendees"`
+	Version      int64     `json:"version" db:"version" readOnly:"true" version:"true"`
 }
 
 func (Event) TableName() string {
@@ -23,3 +50,29 @@ func (Event) EmptySlice() interface{} {
 func (e Event) GetID() int64 {
 	return e.ID
 }
+
+// PreWrite gives an event without an end date its default duration, and one
+// without a time zone UTC. An RRULE: prefix is dropped from its rule.
+func (e Event) PreWrite() (Model, error) {
+	if e.Timezone == "" {
+		e.Timezone = "UTC"
+	}
+	e.RRule = strings.TrimPrefix(strings.TrimSpace(e.RRule), "RRULE:")
+	if e.EndDate.IsZero() {
+		if e.AllDay {
+			e.EndDate = e.StartDate.Add(AllDayEventDuration)
+		} else {
+			e.EndDate = e.StartDate.Add(DefaultEventDuration)
+		}
+	}
+	return e, nil
+}
+
+// In returns a copy of the event with its times in loc.
+func (e Event) In(loc *time.Location) Event {
+	e.StartDate = e.StartDate.In(loc)
+	e.EndDate = e.EndDate.In(loc)
+	e.CreatedAt = e.CreatedAt.In(loc)
+	e.ExDates = e.ExDates.In(loc)
+	return e
+}
@@ -1,11 +1,10 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"reflect"
-
-	"github.com/go-playground/validator"
 )
 
 type Model interface {
@@ -14,27 +13,102 @@ type Model interface {
 	EmptySlice() interface{}
 }
 
+// PreWriter is implemented by models that need to transform their values
+// before being written to the db, e.g. hashing a password. PreWrite returns the
+// model that should be written in place of the original.
+type PreWriter interface {
+	PreWrite() (Model, error)
+}
+
 // go-playground/validator suggests using a single instance of the validator, I
 // may end up needing to instantiate this higher up in the data flow? Seems fine
 // for now Alternatively, expose it as a const here and import in the main
 // package and attach to the app struct
-var validate = validator.New()
+var validate = newValidator()
 
 // ValidateModel validates a model using the go-playground/validator package. It
-// returns an error if the provided argument does not implement the Model
-// interface.
+// returns ValidationErrors if the model is invalid, or an error if the provided
+// argument does not implement the Model interface.
 func ValidateModel(model interface{}) error {
+	return ValidateModelContext(context.Background(), model)
+}
+
+// ValidateModelContext is ValidateModel with a context, which the rules that
+// depend on more than the model read what they need from; see WithUserLookup
+// and WithStoredStart. If a rule can't be checked at all, e.g. because a user
+// couldn't be looked up, it returns ErrValidationIncomplete rather than
+// ValidationErrors.
+func ValidateModelContext(ctx context.Context, model interface{}) error {
 	m, ok := model.(Model)
 	if !ok {
 		return fmt.Errorf("expected model, got %T", m)
 	}
 
-	if err := validate.Struct(m); err != nil {
-		return err
+	ctx, failure := withRuleFailure(ctx)
+	err := validate.StructCtx(ctx, m)
+	if failure.err != nil {
+		return failure.err
+	}
+	if err != nil {
+		return validationErrors(err)
 	}
 	return nil
 }
 
+// ValidateModelFields validates only the given fields of a model, named by their
+// JSON tags, e.g. the fields supplied in a partial update. It returns an error
+// if the model doesn't have one of the fields.
+func ValidateModelFields(model interface{}, fields []string) error {
+	return ValidateModelFieldsContext(context.Background(), model, fields)
+}
+
+// ValidateModelFieldsContext is ValidateModelFields with a context, as for
+// ValidateModelContext.
+func ValidateModelFieldsContext(ctx context.Context, model interface{}, fields []string) error {
+	m, ok := model.(Model)
+	if !ok {
+		return fmt.Errorf("expected model, got %T", m)
+	}
+
+	typ := reflect.TypeOf(m)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	fieldNames := make(map[string]string, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		fieldNames[typ.Field(i).Tag.Get("json")] = typ.Field(i).Name
+	}
+
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		name, ok := fieldNames[f]
+		if !ok {
+			return fmt.Errorf("unknown field: %s", f)
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	ctx, failure := withRuleFailure(ctx)
+	err := validate.StructPartialCtx(ctx, m, names...)
+	if failure.err != nil {
+		return failure.err
+	}
+	return validationErrors(err)
+}
+
+// PrepareForWrite runs the model's PreWrite hook if it has one, and otherwise
+// returns the model unchanged.
+func PrepareForWrite(m Model) (Model, error) {
+	pw, ok := m.(PreWriter)
+	if !ok {
+		return m, nil
+	}
+	return pw.PreWrite()
+}
+
 // GetValsFromModel returns the field values of a model as a slice of
 // interfaces, in the order of the model's column names. It is used for
 // extracting values from the model and writing them to the database. Validation
@@ -163,6 +237,25 @@ func GetColumnNames(m Model, excludeReadOnlyFields bool) []string {
 	return columnNames
 }
 
+// GetVersion returns the column and value of the model's field tagged
+// version:"true", if it has one. Updates to such models only succeed if the
+// version in the db still matches, and they increment it.
+func GetVersion(m Model) (column string, version int64, ok bool) {
+	val := reflect.ValueOf(m)
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	typ := val.Type()
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Tag.Get("version") == "true" {
+			return field.Tag.Get("db"), val.Field(i).Int(), true
+		}
+	}
+	return "", 0, false
+}
+
 // Returns a map of the model's field tags where key is JSON and value is DB
 func MapJsonTagsToDB(m Model) map[string]string {
 	val := reflect.ValueOf(m)
@@ -180,6 +273,23 @@ func MapJsonTagsToDB(m Model) map[string]string {
 	return tagMap
 }
 
+// MapQueryableJsonTagsToDB is MapJsonTagsToDB without the write-only fields.
+// Those must never be filtered or sorted on, or which records a query finds
+// would give their values away.
+func MapQueryableJsonTagsToDB(m Model) map[string]string {
+	typ := reflect.TypeOf(m)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	tagMap := MapJsonTagsToDB(m)
+	for i := 0; i < typ.NumField(); i++ {
+		if typ.Field(i).Tag.Get("writeOnly") == "true" {
+			delete(tagMap, typ.Field(i).Tag.Get("json"))
+		}
+	}
+	return tagMap
+}
+
 // Helper function to determine the initial capacity based on expected rows
 func determineInitialCapacity(expectedRows int) int {
 	switch {
@@ -1,6 +1,12 @@
 package models
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -29,6 +35,7 @@ func TestGetColumnNames(t *testing.T) {
 				"email",
 				"password",
 				"created_at",
+				"role",
 			},
 		},
 		{
@@ -38,6 +45,12 @@ func TestGetColumnNames(t *testing.T) {
 				"name",
 				"description",
 				"start_date",
+				"end_date",
+				"all_day",
+				"timezone",
+				"rrule",
+				"exdates",
+				"uid",
 				"max_attendees",
 			},
 		},
@@ -49,8 +62,15 @@ func TestGetColumnNames(t *testing.T) {
 				"name",
 				"description",
 				"start_date",
+				"end_date",
+				"all_day",
+				"timezone",
+				"rrule",
+				"exdates",
+				"uid",
 				"created_at",
 				"max_attendees",
+				"version",
 			},
 		},
 	}
@@ -63,6 +83,16 @@ func TestGetColumnNames(t *testing.T) {
 	}
 }
 
+func TestGetVersion(t *testing.T) {
+	column, version, ok := GetVersion(Event{Version: 3})
+	assert.True(t, ok)
+	assert.Equal(t, "version", column)
+	assert.Equal(t, int64(3), version)
+
+	_, _, ok = GetVersion(&User{})
+	assert.False(t, ok)
+}
+
 func TestMapJsonTagsToDB(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -76,6 +106,7 @@ func TestMapJsonTagsToDB(t *testing.T) {
 				"email":     "email",
 				"password":  "password",
 				"createdAt": "created_at",
+				"role":      "role",
 			},
 		},
 		{
@@ -86,8 +117,15 @@ func TestMapJsonTagsToDB(t *testing.T) {
 				"name":         "name",
 				"description":  "description",
 				"startDate":    "start_date",
+				"endDate":      "end_date",
+				"allDay":       "all_day",
+				"timezone":     "timezone",
+				"rrule":        "rrule",
+				"exdates":      "exdates",
+				"uid":          "uid",
 				"createdAt":    "created_at",
 				"maxAttendees": "max_attendees",
+				"version":      "version",
 			},
 		},
 	}
@@ -100,6 +138,14 @@ func TestMapJsonTagsToDB(t *testing.T) {
 	}
 }
 
+func TestMapQueryableJsonTagsToDB(t *testing.T) {
+	mappings := MapQueryableJsonTagsToDB(User{})
+	assert.NotContains(t, mappings, "password")
+	assert.Equal(t, "email", mappings["email"])
+
+	assert.Equal(t, MapJsonTagsToDB(Event{}), MapQueryableJsonTagsToDB(Event{}))
+}
+
 type MockModel struct {
 	ID        int64     `db:"id" readOnly:"true"`
 	Name      string    `validate:"required" db:"name"`
@@ -225,3 +271,501 @@ func TestScanToModel(t *testing.T) {
 		assert.Equal(t, "another@example.com", (*modelsSlice)[1].Email)
 	})
 }
+
+func TestUserHashPassword(t *testing.T) {
+	u := User{Email: "hello@example.com", Password: "password"}
+
+	assert.NoError(t, u.HashPassword())
+	assert.NotEqual(t, "password", u.Password)
+	assert.True(t, u.PasswordMatches("password"))
+	assert.False(t, u.PasswordMatches("wrong password"))
+
+	// A password that looks like a hash is a password like any other, so a
+	// client can't pick the stored hash
+	chosen := u.Password
+	u.Password = chosen
+	assert.NoError(t, u.HashPassword())
+	assert.NotEqual(t, chosen, u.Password)
+	assert.True(t, u.PasswordMatches(chosen))
+
+	// Writing a user leaves its password as it is
+	m, err := PrepareForWrite(User{Email: "hello@example.com", Password: "password"})
+	assert.NoError(t, err)
+	assert.Equal(t, "password", m.(User).Password)
+
+	// Models without a PreWrite hook are returned unchanged
+	mm := MockModel{1, "Test", "hello@example.com", time.Now()}
+	m, err = PrepareForWrite(mm)
+	assert.NoError(t, err)
+	assert.Equal(t, mm, m)
+}
+
+func TestUserPasswordLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		valid    bool
+	}{
+		{"72 bytes", strings.Repeat("a", 72), true},
+		{"73 bytes", strings.Repeat("a", 73), false},
+		// 24 characters but 72 bytes, and 25 characters but 75 bytes
+		{"72 multibyte bytes", strings.Repeat("€", 24), true},
+		{"Multibyte over 72 bytes", strings.Repeat("€", 25), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := User{Email: "hello@example.com", Password: tt.password}
+			err := ValidateModel(&u)
+			if tt.valid {
+				assert.NoError(t, err)
+				assert.NoError(t, u.HashPassword())
+				return
+			}
+			var ve ValidationErrors
+			if assert.ErrorAs(t, err, &ve) {
+				assert.Equal(t, "maxbytes", ve[0].Rule)
+				assert.Equal(t, "password must be at most 72 bytes long", ve[0].Message)
+			}
+		})
+	}
+}
+
+func TestStripWriteOnlyFields(t *testing.T) {
+	u := User{ID: 1, Email: "hello@example.com", Password: "secret", CreatedAt: time.Now(), Role: RoleUser}
+
+	type wrapper struct {
+		User  User   `json:"user"`
+		Other string `json:"other"`
+	}
+	type embedding struct {
+		User
+		Extra int `json:"extra"`
+	}
+
+	tests := []struct {
+		name string
+		data interface{}
+	}{
+		{"Struct", u},
+		{"Pointer", &u},
+		{"Slice", []User{u, u}},
+		{"Pointer to slice", &[]User{u}},
+		{"Map", map[string]interface{}{"user": u}},
+		{"Nested struct", wrapper{User: u, Other: "value"}},
+		{"Embedded struct", embedding{User: u, Extra: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := json.Marshal(StripWriteOnlyFields(tt.data))
+			assert.NoError(t, err)
+			assert.NotContains(t, string(payload), "password")
+			assert.NotContains(t, string(payload), "secret")
+			assert.Contains(t, string(payload), `"email":"hello@example.com"`)
+		})
+	}
+
+	t.Run("Values without write-only fields are untouched", func(t *testing.T) {
+		e := Event{ID: 1, Name: "Test Event"}
+		assert.Equal(t, e, StripWriteOnlyFields(e))
+		assert.Nil(t, StripWriteOnlyFields(nil))
+	})
+
+	t.Run("Field order and other tags are preserved", func(t *testing.T) {
+		payload, err := json.Marshal(StripWriteOnlyFields(u))
+		assert.NoError(t, err)
+
+		createdAt, _ := json.Marshal(u.CreatedAt)
+		expected := fmt.Sprintf(`{"id":1,"email":"hello@example.com","createdAt":%s,"role":"user"}`, createdAt)
+		assert.Equal(t, expected, string(payload))
+	})
+}
+
+// withUsers returns a context that looks users up among ids.
+func withUsers(ids ...int64) context.Context {
+	return WithUserLookup(context.Background(), func(ctx context.Context, id int64) (bool, error) {
+		return slices.Contains(ids, id), nil
+	})
+}
+
+func TestValidationErrors(t *testing.T) {
+	err := ValidateModelContext(withUsers(1), Event{UserID: 1, Name: "short", Description: "At the manor hotel"})
+
+	var ve ValidationErrors
+	if assert.ErrorAs(t, err, &ve) {
+		assert.Equal(t, ValidationErrors{
+			{Field: "name", Rule: "min", Param: "8", Message: "name must be at least 8 characters long"},
+			{Field: "startDate", Rule: "required", Message: "startDate is required"},
+		}, ve)
+	}
+	assert.EqualError(t, err, "name must be at least 8 characters long; startDate is required")
+
+	err = ValidateModelFields(&Event{Name: "A long enough name"}, []string{"name"})
+	assert.NoError(t, err)
+
+	err = ValidateModelFields(&Attendee{Status: "maybe"}, []string{"status"})
+	if assert.ErrorAs(t, err, &ve) {
+		assert.Equal(t, "status must be one of: confirmed waitlisted cancelled", ve[0].Message)
+	}
+}
+
+func TestEventValidation(t *testing.T) {
+	valid := Event{
+		UserID:       1,
+		Name:         "Test Event",
+		Description:  "At the manor hotel",
+		StartDate:    time.Now().Add(time.Hour),
+		MaxAttendees: 50,
+	}
+
+	tests := []struct {
+		name          string
+		modify        func(e *Event)
+		expectedRules map[string]string
+	}{
+		{"Valid event", func(e *Event) {}, nil},
+		{"Unlimited capacity", func(e *Event) { e.MaxAttendees = 0 }, nil},
+		{"Start in the past", func(e *Event) { e.StartDate = time.Now().Add(-time.Hour) }, map[string]string{"startDate": "futuredate"}},
+		{"Negative capacity", func(e *Event) { e.MaxAttendees = -1 }, map[string]string{"maxAttendees": "capacity"}},
+		{"Capacity too large", func(e *Event) { e.MaxAttendees = maxCapacity + 1 }, map[string]string{"maxAttendees": "capacity"}},
+		{"Missing owner", func(e *Event) { e.UserID = 0 }, map[string]string{"userId": "required"}},
+		{"Unknown owner", func(e *Event) { e.UserID = 2 }, map[string]string{"userId": "existinguser"}},
+		{"Ends when it starts", func(e *Event) { e.EndDate = e.StartDate }, nil},
+		{"Time zone", func(e *Event) { e.Timezone = "Europe/Berlin" }, nil},
+		{"Unknown time zone", func(e *Event) { e.Timezone = "Mars/Olympus_Mons" }, map[string]string{"timezone": "timezone"}},
+		{"Server's time zone", func(e *Event) { e.Timezone = "Local" }, map[string]string{"timezone": "timezone"}},
+		{"Ends before it starts", func(e *Event) { e.EndDate = e.StartDate.Add(-time.Minute) }, map[string]string{"endDate": "afterstart"}},
+		{"Weekly", func(e *Event) { e.RRule = "FREQ=WEEKLY;COUNT=52" }, nil},
+		{"Rule with its prefix", func(e *Event) { e.RRule = "RRULE:FREQ=WEEKLY;BYDAY=TU" }, nil},
+		{"Malformed rule", func(e *Event) { e.RRule = "every tuesday" }, map[string]string{"rrule": "rrule"}},
+		{"Rule with its own start", func(e *Event) { e.RRule = "DTSTART:20240601T180000Z\nRRULE:FREQ=DAILY" }, map[string]string{"rrule": "rrule"}},
+		{"Rule repeating too often", func(e *Event) { e.RRule = "FREQ=MINUTELY" }, map[string]string{"rrule": "rrule"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := valid
+			tt.modify(&e)
+
+			err := ValidateModelContext(withUsers(1), e)
+			if tt.expectedRules == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			var ve ValidationErrors
+			if assert.ErrorAs(t, err, &ve) {
+				rules := make(map[string]string)
+				for _, fe := range ve {
+					rules[fe.Field] = fe.Rule
+				}
+				assert.Equal(t, tt.expectedRules, rules)
+			}
+		})
+	}
+}
+
+func TestValidationContext(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	t.Run("Stored start", func(t *testing.T) {
+		// Only startDate is checked, as when patching it
+		validate := func(ctx context.Context, start time.Time) error {
+			return ValidateModelFieldsContext(ctx, &Event{StartDate: start}, []string{"startDate"})
+		}
+		ctx := context.Background()
+		assert.NoError(t, validate(ctx, future))
+		assert.NoError(t, validate(WithStoredStart(ctx, past), future))
+		// An event that has started can still be edited, as long as it stays put
+		assert.NoError(t, validate(WithStoredStart(ctx, past), past))
+
+		for _, err := range []error{validate(ctx, past), validate(WithStoredStart(ctx, future), past)} {
+			var ve ValidationErrors
+			if assert.ErrorAs(t, err, &ve) {
+				assert.Equal(t, ValidationErrors{{Field: "startDate", Rule: "futuredate", Message: "startDate must be in the future"}}, ve)
+			}
+		}
+	})
+
+	e := Event{UserID: 1, Name: "Test Event", Description: "At the manor hotel", StartDate: future}
+
+	t.Run("Users are looked up once", func(t *testing.T) {
+		lookups := 0
+		ctx := WithUserLookup(context.Background(), func(ctx context.Context, id int64) (bool, error) {
+			lookups++
+			return true, nil
+		})
+		assert.NoError(t, ValidateModelContext(ctx, e))
+		assert.NoError(t, ValidateModelContext(ctx, e))
+		assert.Equal(t, 1, lookups)
+	})
+
+	t.Run("Users that can't be looked up", func(t *testing.T) {
+		ctx := WithUserLookup(context.Background(), func(ctx context.Context, id int64) (bool, error) {
+			return false, errors.New("connection reset")
+		})
+		err := ValidateModelContext(ctx, e)
+		assert.ErrorIs(t, err, ErrValidationIncomplete)
+		assert.ErrorContains(t, err, "connection reset")
+
+		err = ValidateModel(e)
+		assert.ErrorIs(t, err, ErrValidationIncomplete)
+		assert.ErrorIs(t, err, ErrNoUserLookup)
+	})
+}
+
+func TestEventPreWrite(t *testing.T) {
+	start := time.Now().Add(time.Hour)
+
+	m, err := PrepareForWrite(Event{StartDate: start})
+	assert.NoError(t, err)
+	assert.Equal(t, start.Add(DefaultEventDuration), m.(Event).EndDate)
+
+	m, err = PrepareForWrite(Event{StartDate: start, AllDay: true})
+	assert.NoError(t, err)
+	assert.Equal(t, start.Add(AllDayEventDuration), m.(Event).EndDate)
+
+	end := start.Add(3 * time.Hour)
+	m, err = PrepareForWrite(Event{StartDate: start, EndDate: end, Timezone: "Europe/Berlin"})
+	assert.NoError(t, err)
+	assert.Equal(t, end, m.(Event).EndDate)
+	assert.Equal(t, "Europe/Berlin", m.(Event).Timezone)
+
+	m, err = PrepareForWrite(Event{StartDate: start})
+	assert.NoError(t, err)
+	assert.Equal(t, "UTC", m.(Event).Timezone)
+
+	m, err = PrepareForWrite(Event{StartDate: start, RRule: "RRULE:FREQ=WEEKLY"})
+	assert.NoError(t, err)
+	assert.Equal(t, "FREQ=WEEKLY", m.(Event).RRule)
+}
+
+func TestEventIn(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	assert.NoError(t, err)
+
+	start := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	e := Event{StartDate: start, EndDate: start.Add(time.Hour), CreatedAt: start}.In(tokyo)
+
+	assert.Equal(t, "2024-06-01T18:00:00+09:00", e.StartDate.Format(time.RFC3339))
+	assert.Equal(t, "2024-06-01T19:00:00+09:00", e.EndDate.Format(time.RFC3339))
+	assert.True(t, e.StartDate.Equal(start))
+}
+
+func TestEventOccurrences(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NoError(t, err)
+
+	// Weekly on Tuesdays at 18:00 Berlin time, across the end of summer time
+	start := time.Date(2024, 10, 1, 18, 0, 0, 0, berlin)
+	weekly := Event{
+		ID:        1,
+		StartDate: start.UTC(),
+		EndDate:   start.Add(2 * time.Hour).UTC(),
+		Timezone:  "Europe/Berlin",
+		RRule:     "FREQ=WEEKLY;COUNT=6",
+	}
+	week := func(n int) time.Time { return start.AddDate(0, 0, 7*n) }
+	starts := func(occurrences []Occurrence) []string {
+		var s []string
+		for _, o := range occurrences {
+			s = append(s, o.StartDate.In(berlin).Format("2006-01-02 15:04"))
+		}
+		return s
+	}
+
+	tests := []struct {
+		name      string
+		event     func(e Event) Event
+		from, to  time.Time
+		overrides []OccurrenceOverride
+		expected  []string
+	}{
+		{
+			name:     "Whole series",
+			event:    func(e Event) Event { return e },
+			from:     week(0),
+			to:       week(10),
+			expected: []string{"2024-10-01 18:00", "2024-10-08 18:00", "2024-10-15 18:00", "2024-10-22 18:00", "2024-10-29 18:00", "2024-11-05 18:00"},
+		},
+		{
+			name:     "Window overlapping the end of an occurrence",
+			event:    func(e Event) Event { return e },
+			from:     week(1).Add(time.Hour),
+			to:       week(2).Add(-time.Hour),
+			expected: []string{"2024-10-08 18:00"},
+		},
+		{
+			name: "Exception dates",
+			event: func(e Event) Event {
+				e.ExDates = TimeList{week(1), week(3)}
+				return e
+			},
+			from:     week(0),
+			to:       week(4),
+			expected: []string{"2024-10-01 18:00", "2024-10-15 18:00", "2024-10-29 18:00"},
+		},
+		{
+			name:      "Cancelled occurrence",
+			event:     func(e Event) Event { return e },
+			from:      week(0),
+			to:        week(2),
+			overrides: []OccurrenceOverride{{OriginalStart: week(1), Cancelled: true}},
+			expected:  []string{"2024-10-01 18:00", "2024-10-15 18:00"},
+		},
+		{
+			name:      "Occurrence moved within the window",
+			event:     func(e Event) Event { return e },
+			from:      week(0),
+			to:        week(2),
+			overrides: []OccurrenceOverride{{OriginalStart: week(1), StartDate: week(1).Add(-24 * time.Hour), EndDate: week(1).Add(-22 * time.Hour)}},
+			expected:  []string{"2024-10-01 18:00", "2024-10-07 18:00", "2024-10-15 18:00"},
+		},
+		{
+			name:      "Occurrence moved into the window",
+			event:     func(e Event) Event { return e },
+			from:      week(2),
+			to:        week(2).Add(time.Hour),
+			overrides: []OccurrenceOverride{{OriginalStart: week(3), StartDate: week(2).Add(-time.Hour), EndDate: week(2).Add(time.Hour)}},
+			expected:  []string{"2024-10-15 17:00", "2024-10-15 18:00"},
+		},
+		{
+			name:      "Occurrence moved out of the window",
+			event:     func(e Event) Event { return e },
+			from:      week(1),
+			to:        week(1).Add(time.Hour),
+			overrides: []OccurrenceOverride{{OriginalStart: week(1), StartDate: week(5), EndDate: week(5).Add(time.Hour)}},
+			expected:  nil,
+		},
+		{
+			name: "Event that doesn't recur",
+			event: func(e Event) Event {
+				e.RRule = ""
+				return e
+			},
+			from:     week(0).Add(time.Hour),
+			to:       week(10),
+			expected: []string{"2024-10-01 18:00"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			occurrences, err := tt.event(weekly).Occurrences(tt.from, tt.to, tt.overrides)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, starts(occurrences))
+		})
+	}
+
+	t.Run("Occurrences keep their original start", func(t *testing.T) {
+		moved := week(1).Add(time.Hour)
+		overrides := []OccurrenceOverride{{OriginalStart: week(1), StartDate: moved, EndDate: moved.Add(time.Hour)}}
+		occurrences, err := weekly.Occurrences(week(1), week(1).Add(time.Hour), overrides)
+		assert.NoError(t, err)
+		if assert.Len(t, occurrences, 1) {
+			assert.True(t, occurrences[0].OriginalStart.Equal(week(1)))
+			assert.True(t, occurrences[0].EndDate.Equal(moved.Add(time.Hour)))
+			assert.Equal(t, weekly.ID, occurrences[0].ID)
+		}
+	})
+
+	t.Run("Rules repeating too often for the window", func(t *testing.T) {
+		hourly := weekly
+		hourly.RRule = "FREQ=HOURLY"
+		hourly.EndDate = hourly.StartDate.Add(time.Hour)
+
+		occurrences, err := hourly.Occurrences(week(0), week(4), nil)
+		assert.NoError(t, err)
+		assert.Len(t, occurrences, 4*7*24+1)
+
+		_, err = hourly.Occurrences(week(0), week(6), nil)
+		assert.ErrorIs(t, err, ErrTooManyOccurrences)
+	})
+
+	t.Run("Old rules are walked from near the window", func(t *testing.T) {
+		// Started years before the windows, one of them at a time of day
+		// that doesn't exist when the clocks go forward
+		old := time.Date(2015, 3, 3, 2, 30, 0, 0, berlin)
+		windows := [][2]time.Time{
+			{time.Date(2024, 3, 30, 0, 0, 0, 0, berlin), time.Date(2024, 4, 2, 0, 0, 0, 0, berlin)},
+			{time.Date(2024, 10, 26, 12, 0, 0, 0, berlin), time.Date(2024, 10, 28, 12, 0, 0, 0, berlin)},
+		}
+		for _, rule := range []string{
+			"FREQ=HOURLY",
+			"FREQ=HOURLY;INTERVAL=5",
+			"FREQ=HOURLY;INTERVAL=36;BYDAY=MO,WE,SA",
+			"FREQ=DAILY;INTERVAL=3",
+			"FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,SA",
+			"FREQ=WEEKLY;UNTIL=20240401T000000Z",
+		} {
+			e := Event{
+				ID:        1,
+				StartDate: old.UTC(),
+				EndDate:   old.Add(90 * time.Minute).UTC(),
+				Timezone:  "Europe/Berlin",
+				RRule:     rule,
+			}
+			// The whole series, walked from its start
+			full, err := e.recurrenceFrom(e.StartDate)
+			assert.NoError(t, err)
+			assert.True(t, full.GetDTStart().Equal(e.StartDate))
+
+			for _, w := range windows {
+				occurrences, err := e.Occurrences(w[0], w[1], nil)
+				assert.NoError(t, err)
+
+				// The rule gives the hour the clocks skip twice, as the hour
+				// after it
+				var expected []string
+				for _, start := range full.Between(w[0].Add(-90*time.Minute), w[1], true) {
+					s := start.In(berlin).Format("2006-01-02 15:04")
+					if len(expected) == 0 || expected[len(expected)-1] != s {
+						expected = append(expected, s)
+					}
+				}
+				assert.Equal(t, expected, starts(occurrences), rule)
+
+				r, err := e.recurrenceFrom(w[0])
+				assert.NoError(t, err)
+				assert.True(t, r.GetDTStart().After(w[0].AddDate(0, 0, -16)), rule)
+			}
+		}
+	})
+
+	t.Run("IsOccurrence", func(t *testing.T) {
+		assert.True(t, weekly.IsOccurrence(week(5)))
+		assert.False(t, weekly.IsOccurrence(week(6)))
+		assert.False(t, weekly.IsOccurrence(week(1).Add(time.Hour)))
+
+		weekly.ExDates = TimeList{week(1)}
+		assert.False(t, weekly.IsOccurrence(week(1)))
+	})
+}
+
+func TestTimeList(t *testing.T) {
+	times := TimeList{
+		time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC),
+		time.Date(2024, 6, 8, 18, 30, 0, 0, time.UTC),
+	}
+
+	v, err := times.Value()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"2024-06-01T18:00:00Z","2024-06-08T18:30:00Z"}`, v)
+
+	v, err = TimeList(nil).Value()
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", v)
+
+	var scanned TimeList
+	assert.NoError(t, scanned.Scan(`{"2024-06-01 18:00:00+00","2024-06-08 20:30:00+02"}`))
+	if assert.Len(t, scanned, 2) {
+		assert.True(t, scanned[0].Equal(times[0]))
+		assert.True(t, scanned[1].Equal(times[1]))
+	}
+
+	assert.NoError(t, scanned.Scan("{}"))
+	assert.Empty(t, scanned)
+	assert.Error(t, scanned.Scan(42))
+}
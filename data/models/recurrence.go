@@ -0,0 +1,282 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/teambition/rrule-go"
+)
+
+// MaxOccurrences is the most occurrences of a single event that Occurrences
+// expands in one window; an hourly rule reaches it in under six weeks.
+const MaxOccurrences = 1000
+
+// ErrTooManyOccurrences is returned by Occurrences when a window holds more
+// than MaxOccurrences occurrences of the event.
+var ErrTooManyOccurrences = fmt.Errorf("more than %d occurrences of one event", MaxOccurrences)
+
+// TimeList is a list of times stored in a TIMESTAMPTZ[] column.
+type TimeList []time.Time
+
+// Value writes the list as a postgres array literal.
+func (tl TimeList) Value() (driver.Value, error) {
+	elems := make([]string, len(tl))
+	for i, t := range tl {
+		elems[i] = `"` + t.Format(time.RFC3339Nano) + `"`
+	}
+	return "{" + strings.Join(elems, ",") + "}", nil
+}
+
+// Scan reads a postgres array, which the driver hands over in its text form.
+func (tl *TimeList) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*tl = nil
+		return nil
+	case TimeList:
+		// Mocked drivers pass the value through as it is
+		*tl = append(TimeList(nil), src...)
+		return nil
+	case string, []byte:
+		var arr pgtype.TimestamptzArray
+		if err := arr.Scan(src); err != nil {
+			return err
+		}
+		var times []time.Time
+		if err := arr.AssignTo(&times); err != nil {
+			return err
+		}
+		*tl = times
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into TimeList", src)
+}
+
+// In returns a copy of the list with its times in loc.
+func (tl TimeList) In(loc *time.Location) TimeList {
+	if tl == nil {
+		return nil
+	}
+	in := make(TimeList, len(tl))
+	for i, t := range tl {
+		in[i] = t.In(loc)
+	}
+	return in
+}
+
+func (tl TimeList) contains(t time.Time) bool {
+	for _, x := range tl {
+		if x.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// OccurrenceOverride changes a single occurrence of a recurring event: it is
+// either cancelled, or moved to run from StartDate to EndDate. OriginalStart
+// identifies the occurrence, as the start its event's rule gives it.
+type OccurrenceOverride struct {
+	ID            int64     `json:"id" db:"id" readOnly:"true"`
+	EventID       int64     `json:"eventId" db:"event_id"`
+	OriginalStart time.Time `validate:"required" json:"originalStart" db:"original_start"`
+	Cancelled     bool      `json:"cancelled" db:"cancelled"`
+	StartDate     time.Time `validate:"required" json:"startDate" db:"start_date"`
+	EndDate       time.Time `validate:"required" json:"endDate" db:"end_date"`
+	CreatedAt     time.Time `json:"createdAt" db:"created_at" readOnly:"true"`
+}
+
+func (OccurrenceOverride) TableName() string {
+	return "occurrence_overrides"
+}
+
+func (OccurrenceOverride) EmptySlice() interface{} {
+	return &[]OccurrenceOverride{}
+}
+
+func (o OccurrenceOverride) GetID() int64 {
+	return o.ID
+}
+
+// Occurrence is a single instance of an event. An event that doesn't recur has
+// one occurrence, which is the event itself.
+type Occurrence struct {
+	Event
+	// OriginalStart identifies the occurrence within its series. It differs
+	// from StartDate if the occurrence has been moved.
+	OriginalStart time.Time `json:"originalStart"`
+}
+
+// In returns a copy of the occurrence with its times in loc.
+func (o Occurrence) In(loc *time.Location) Occurrence {
+	o.Event = o.Event.In(loc)
+	o.OriginalStart = o.OriginalStart.In(loc)
+	return o
+}
+
+// recurrenceFrom parses the event's rule, for walking its occurrences from t
+// on. The rule is evaluated in the event's own time zone, so e.g. a weekly
+// 18:00 meetup stays at 18:00 when the clocks change.
+//
+// The series is anchored at the event's start, but a rule without a COUNT is
+// anchored as close before t as it can be without changing its occurrences
+// from t on; see skipPeriods. Otherwise an hourly rule started years ago would
+// be walked through hundreds of thousands of occurrences to reach t. A rule
+// with a COUNT has to be walked from the start to count, but goes no further
+// than its COUNT.
+func (e Event) recurrenceFrom(t time.Time) (*rrule.RRule, error) {
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	opt, err := rrule.StrToROptionInLocation(e.RRule, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rrule %q: %w", e.RRule, err)
+	}
+	opt.Dtstart = e.StartDate.In(loc)
+	if opt.Count == 0 {
+		opt.Dtstart = skipPeriods(*opt, t.In(loc))
+	}
+	return rrule.NewRRule(*opt)
+}
+
+// skipPeriods returns the start of the rule moved on by as many whole periods
+// as fit before t, for rules repeating at least weekly; later ones aren't
+// worth it. The rule steps through hours on a grid of whole days of its zone,
+// so the start is moved a whole number of days that is also a whole number of
+// periods, keeping the grid, the time of day and the weekday the rule is
+// anchored to.
+func skipPeriods(opt rrule.ROption, t time.Time) time.Time {
+	start := opt.Dtstart
+	interval := max(opt.Interval, 1)
+	var days int
+	switch opt.Freq {
+	case rrule.WEEKLY:
+		days = 7 * interval
+	case rrule.DAILY:
+		days = interval
+	case rrule.HOURLY:
+		days = interval / gcd(interval, 24)
+	default:
+		return start
+	}
+
+	// A day short of 24 hours where the clocks go forward is made up for by
+	// leaving a day to spare
+	for n := (int(t.Sub(start)/(24*time.Hour)) - 1) / days; n > 0; n-- {
+		moved := start.AddDate(0, 0, n*days)
+		// A start that falls in the gap when the clocks go forward would be
+		// moved off its time of day; the period before it is kept instead
+		h, m, s := moved.Clock()
+		if sh, sm, ss := start.Clock(); h == sh && m == sm && s == ss && !moved.After(t) {
+			return moved
+		}
+	}
+	return start
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// IsOccurrence reports whether the event has an occurrence starting at t,
+// going by its rule and exception dates. Overrides don't change the answer.
+func (e Event) IsOccurrence(t time.Time) bool {
+	if e.RRule == "" {
+		return t.Equal(e.StartDate)
+	}
+	return e.isRuleStart(t) && !e.ExDates.contains(t)
+}
+
+// isRuleStart reports whether the event's rule has an occurrence starting at
+// t, exception dates aside.
+func (e Event) isRuleStart(t time.Time) bool {
+	r, err := e.recurrenceFrom(t)
+	if err != nil {
+		return false
+	}
+	return len(r.Between(t, t, true)) > 0
+}
+
+// Occurrences returns the event's occurrences that overlap the window from-to,
+// sorted by start. A recurring event is expanded according to its rule, less
+// its exception dates, with the given overrides applied: cancelled occurrences
+// are left out and moved ones take their new times. It returns
+// ErrTooManyOccurrences rather than expanding more than MaxOccurrences.
+func (e Event) Occurrences(from, to time.Time, overrides []OccurrenceOverride) ([]Occurrence, error) {
+	overlaps := func(start, end time.Time) bool {
+		return !start.After(to) && !end.Before(from)
+	}
+
+	if e.RRule == "" {
+		if !overlaps(e.StartDate, e.EndDate) {
+			return nil, nil
+		}
+		return []Occurrence{{Event: e, OriginalStart: e.StartDate}}, nil
+	}
+
+	duration := e.EndDate.Sub(e.StartDate)
+	r, err := e.recurrenceFrom(from.Add(-duration))
+	if err != nil {
+		return nil, err
+	}
+
+	overridden := make(map[int64]OccurrenceOverride, len(overrides))
+	for _, o := range overrides {
+		overridden[o.OriginalStart.UnixNano()] = o
+	}
+
+	var occurrences []Occurrence
+	seen := make(map[int64]bool)
+	add := func(start time.Time) {
+		key := start.UnixNano()
+		if seen[key] || e.ExDates.contains(start) {
+			return
+		}
+		seen[key] = true
+
+		o := Occurrence{Event: e, OriginalStart: start}
+		o.StartDate, o.EndDate = start, start.Add(duration)
+		if override, ok := overridden[key]; ok {
+			if override.Cancelled {
+				return
+			}
+			o.StartDate, o.EndDate = override.StartDate, override.EndDate
+		}
+		if overlaps(o.StartDate, o.EndDate) {
+			occurrences = append(occurrences, o)
+		}
+	}
+
+	// The rule is walked rather than expanded with Between, so a rule that
+	// repeats often is stopped before it fills the window
+	expanded := 0
+	next := r.Iterator()
+	for start, ok := next(); ok && !start.After(to); start, ok = next() {
+		if start.Before(from.Add(-duration)) {
+			continue
+		}
+		if expanded++; expanded > MaxOccurrences {
+			return nil, ErrTooManyOccurrences
+		}
+		add(start)
+	}
+	// An occurrence may have been moved into the window from outside it
+	for _, o := range overrides {
+		if !o.Cancelled && e.isRuleStart(o.OriginalStart) {
+			add(o.OriginalStart)
+		}
+	}
+
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		return occurrences[i].StartDate.Before(occurrences[j].StartDate)
+	})
+	return occurrences, nil
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// RefreshToken is a long-lived token that can be exchanged for a new access
+// token. Only a hash of the token is stored, so a leaked table can't be used
+// to log in.
+type RefreshToken struct {
+	ID        int64     `json:"id" db:"id" readOnly:"true"`
+	UserID    int64     `json:"userId" db:"user_id"`
+	TokenHash string    `json:"tokenHash" db:"token_hash" writeOnly:"true"`
+	ExpiresAt time.Time `json:"expiresAt" db:"expires_at"`
+	Revoked   bool      `json:"revoked" db:"revoked"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at" readOnly:"true"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+func (RefreshToken) EmptySlice() interface{} {
+	return &[]RefreshToken{}
+}
+
+func (rt RefreshToken) GetID() int64 {
+	return rt.ID
+}
+
+// IsActive reports whether the token can still be exchanged.
+func (rt RefreshToken) IsActive() bool {
+	return !rt.Revoked && time.Now().Before(rt.ExpiresAt)
+}
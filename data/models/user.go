@@ -1,12 +1,27 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
 
 type User struct {
-	ID        int64     `json:"id" db:"id" readOnly:"true"`
-	Email     string    `validate:"required,email" json:"email" db:"email"`
-	Password  string    `validate:"min=6,max=120" json:"password" db:"password"`
+	ID    int64  `json:"id" db:"id" readOnly:"true"`
+	Email string `validate:"required,email" json:"email" db:"email"`
+	// Password is the plaintext a client sends, and the bcrypt hash that is
+	// stored; see HashPassword. bcrypt refuses passwords over 72 bytes, which
+	// may be fewer than 72 characters
+	Password  string    `validate:"min=6,maxbytes=72" json:"password" db:"password" writeOnly:"true"`
 	CreatedAt time.Time `json:"createdAt" db:"created_at" readOnly:"true"`
+	// Roles can't be changed through the API, new users always get RoleUser
+	Role string `json:"role" db:"role" readOnly:"true"`
 }
 
 func (User) TableName() string {
@@ -20,3 +35,27 @@ func (User) EmptySlice() interface{} {
 func (u User) GetID() int64 {
 	return u.ID
 }
+
+func (u User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// HashPassword replaces the user's plaintext password with its bcrypt hash.
+// The password is stored as it is, so whoever sets a password has to hash it
+// before writing the user; a user read from the db already holds its hash.
+// Whether a password is a hash is never guessed from what it looks like, or a
+// client could set a hash of their choosing.
+func (u *User) HashPassword() error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("error hashing password: %w", err)
+	}
+	u.Password = string(hash)
+	return nil
+}
+
+// PasswordMatches reports whether the plaintext password matches the user's
+// hashed password.
+func (u User) PasswordMatches(plaintext string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(plaintext)) == nil
+}
@@ -0,0 +1,337 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	// Time zone names are checked against the embedded database, so the
+	// result doesn't depend on the host having one
+	_ "time/tzdata"
+
+	"github.com/go-playground/locales/en"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator"
+	"github.com/teambition/rrule-go"
+)
+
+// FieldError describes why a single field failed validation.
+type FieldError struct {
+	// Field is the field's JSON name, e.g. "startDate"
+	Field string `json:"field"`
+	// Rule is the validation tag that failed, e.g. "min"
+	Rule string `json:"rule"`
+	// Param is the rule's parameter, e.g. "8" for min=8
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is returned by ValidateModel and ValidateModelFields when a
+// model fails validation, with an entry for each failed rule.
+type ValidationErrors []FieldError
+
+func (ve ValidationErrors) Error() string {
+	messages := make([]string, len(ve))
+	for i, fe := range ve {
+		messages[i] = fe.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// enMessages are the English validation messages, keyed by rule. Rules whose
+// wording depends on the kind of field have a key per kind, e.g. "min-string";
+// {0} is the field and {1} the rule's parameter.
+var enMessages = map[string]string{
+	"required":     "{0} is required",
+	"email":        "{0} must be a valid email address",
+	"oneof":        "{0} must be one of: {1}",
+	"min-string":   "{0} must be at least {1} characters long",
+	"min-number":   "{0} must be {1} or greater",
+	"min-items":    "{0} must contain at least {1} items",
+	"max-string":   "{0} must be at most {1} characters long",
+	"max-number":   "{0} must be {1} or less",
+	"max-items":    "{0} must contain at most {1} items",
+	"maxbytes":     "{0} must be at most {1} bytes long",
+	"futuredate":   "{0} must be in the future",
+	"capacity":     fmt.Sprintf("{0} must be between 0 (unlimited) and %d", maxCapacity),
+	"existinguser": "{0} must refer to an existing user",
+	"afterstart":   "{0} must not be before startDate",
+	"timezone":     "{0} must be an IANA time zone name, e.g. Europe/Berlin",
+	"rrule":        "{0} must be an RRULE repeating at most hourly, without DTSTART, e.g. FREQ=WEEKLY;COUNT=10",
+	"default":      "{0} is invalid",
+}
+
+// translator renders validation messages. It is a universal-translator, so
+// supporting another language means adding a translator with its own set of
+// messages.
+var translator = newTranslator("en", enMessages)
+
+func newTranslator(locale string, messages map[string]string) ut.Translator {
+	english := en.New()
+	trans, found := ut.New(english, english).GetTranslator(locale)
+	if !found {
+		panic("no translator for locale " + locale)
+	}
+	for key, text := range messages {
+		if err := trans.Add(key, text, false); err != nil {
+			panic(err)
+		}
+	}
+	return trans
+}
+
+// newValidator returns a validator that reports fields by their JSON names,
+// with the app's own rules registered:
+//
+//   - maxbytes: a string at most the parameter's number of bytes long, where
+//     max counts characters
+//   - capacity: an attendee limit from 0, meaning unlimited, to maxCapacity
+//   - timezone: an IANA time zone name, or empty for the default
+//   - rrule: an RFC 5545 recurrence rule, or empty for none
+//   - futuredate: a time in the future, unless it is the one given to
+//     WithStoredStart
+//   - existinguser: the ID of a user, checked with the lookup given to
+//     WithUserLookup
+//
+// and the end dates of events and occurrence overrides checked against their
+// start dates.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	rules := map[string]validator.Func{
+		"maxbytes": isAtMostBytes,
+		"capacity": isCapacity,
+		"timezone": isTimezone,
+		"rrule":    isRRule,
+	}
+	for tag, fn := range rules {
+		if err := v.RegisterValidation(tag, fn); err != nil {
+			panic(err)
+		}
+	}
+	ctxRules := map[string]validator.FuncCtx{
+		"futuredate":   isFutureStart,
+		"existinguser": isExistingUser,
+	}
+	for tag, fn := range ctxRules {
+		if err := v.RegisterValidationCtx(tag, fn); err != nil {
+			panic(err)
+		}
+	}
+	v.RegisterStructValidation(validateEventDates, Event{})
+	v.RegisterStructValidation(validateOverrideDates, OccurrenceOverride{})
+	return v
+}
+
+func isAtMostBytes(fl validator.FieldLevel) bool {
+	limit, err := strconv.Atoi(fl.Param())
+	if err != nil {
+		panic(fmt.Sprintf("bad maxbytes parameter %q", fl.Param()))
+	}
+	return len(fl.Field().String()) <= limit
+}
+
+// maxCapacity is the most attendees an event may be limited to.
+const maxCapacity = 100000
+
+func isCapacity(fl validator.FieldLevel) bool {
+	n := fl.Field().Int()
+	return n >= 0 && n <= maxCapacity
+}
+
+func isTimezone(fl validator.FieldLevel) bool {
+	name := fl.Field().String()
+	if name == "" {
+		return true
+	}
+	// LoadLocation takes "Local" to mean the server's own zone
+	if name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// validateEventDates checks that an event doesn't end before it starts. It runs
+// on partial validation too, so moving just the start of an event past its end
+// is caught. A missing end date is fine; Event.PreWrite fills it in.
+func validateEventDates(sl validator.StructLevel) {
+	e := sl.Current().Interface().(Event)
+	if !e.EndDate.IsZero() && e.EndDate.Before(e.StartDate) {
+		sl.ReportError(e.EndDate, "endDate", "EndDate", "afterstart", "")
+	}
+}
+
+// validateOverrideDates checks that a moved occurrence doesn't end before it
+// starts.
+func validateOverrideDates(sl validator.StructLevel) {
+	o := sl.Current().Interface().(OccurrenceOverride)
+	if o.EndDate.Before(o.StartDate) {
+		sl.ReportError(o.EndDate, "endDate", "EndDate", "afterstart", "")
+	}
+}
+
+// isRRule accepts the RRULE property of RFC 5545, with or without its RRULE:
+// prefix. The series always starts at the event's start, so the rule can't
+// set DTSTART, and rules repeating more than hourly are turned away because
+// of the number of occurrences they would expand into.
+func isRRule(fl validator.FieldLevel) bool {
+	rule := fl.Field().String()
+	if rule == "" {
+		return true
+	}
+	if strings.Contains(rule, "DTSTART") || strings.Contains(rule, "\n") {
+		return false
+	}
+	opt, err := rrule.StrToROption(rule)
+	if err != nil || opt.Freq == rrule.MINUTELY || opt.Freq == rrule.SECONDLY {
+		return false
+	}
+	_, err = rrule.NewRRule(*opt)
+	return err == nil
+}
+
+type (
+	ruleFailureKey struct{}
+	storedStartKey struct{}
+	userLookupKey  struct{}
+)
+
+// ErrValidationIncomplete is returned by ValidateModelContext when a rule
+// couldn't be checked, wrapping the reason.
+var ErrValidationIncomplete = errors.New("validation couldn't be completed")
+
+// ruleFailure holds the error that stopped a rule from being checked, if any,
+// for ValidateModelContext to return.
+type ruleFailure struct {
+	err error
+}
+
+func withRuleFailure(ctx context.Context) (context.Context, *ruleFailure) {
+	failure := &ruleFailure{}
+	return context.WithValue(ctx, ruleFailureKey{}, failure), failure
+}
+
+// fail records why a rule couldn't be checked. The rule should then pass, so
+// the error is all that's reported.
+func fail(ctx context.Context, err error) {
+	if failure, ok := ctx.Value(ruleFailureKey{}).(*ruleFailure); ok && failure.err == nil {
+		failure.err = fmt.Errorf("%w: %w", ErrValidationIncomplete, err)
+	}
+}
+
+// WithStoredStart returns a copy of ctx for validating a change to an event
+// stored with the given start. An event that keeps its start passes futuredate
+// even once it has begun, so it can still be edited, but it can't be moved to
+// another time in the past. Without it, an event is taken to be new.
+func WithStoredStart(ctx context.Context, start time.Time) context.Context {
+	return context.WithValue(ctx, storedStartKey{}, start)
+}
+
+func isFutureStart(ctx context.Context, fl validator.FieldLevel) bool {
+	start, ok := fl.Field().Interface().(time.Time)
+	if !ok {
+		return false
+	}
+	if stored, ok := ctx.Value(storedStartKey{}).(time.Time); ok && start.Equal(stored) {
+		return true
+	}
+	return start.After(time.Now())
+}
+
+// UserLookup reports whether the user with the given ID exists. It is called
+// with the context validation was run with.
+type UserLookup func(ctx context.Context, id int64) (bool, error)
+
+// ErrNoUserLookup is returned when validating a model that refers to a user
+// with a context that has no UserLookup.
+var ErrNoUserLookup = errors.New("no user lookup to check the user against; see WithUserLookup")
+
+// userLookup is a UserLookup that remembers the users it has found, so a
+// context validating many models, e.g. the events of an import, looks each
+// user up once. It isn't safe for concurrent use.
+type userLookup struct {
+	lookup UserLookup
+	found  map[int64]bool
+}
+
+// WithUserLookup returns a copy of ctx that existinguser checks users with.
+// The lookup should run in the caller's transaction, if it has one.
+func WithUserLookup(ctx context.Context, lookup UserLookup) context.Context {
+	return context.WithValue(ctx, userLookupKey{}, &userLookup{lookup: lookup, found: make(map[int64]bool)})
+}
+
+func isExistingUser(ctx context.Context, fl validator.FieldLevel) bool {
+	l, ok := ctx.Value(userLookupKey{}).(*userLookup)
+	if !ok {
+		fail(ctx, ErrNoUserLookup)
+		return true
+	}
+	id := fl.Field().Int()
+	if found, ok := l.found[id]; ok {
+		return found
+	}
+	found, err := l.lookup(ctx, id)
+	if err != nil {
+		fail(ctx, fmt.Errorf("error looking up user %d: %w", id, err))
+		return true
+	}
+	l.found[id] = found
+	return found
+}
+
+// validationErrors converts the validator's errors into ValidationErrors.
+// Other errors are returned as they are.
+func validationErrors(err error) error {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+
+	ve := make(ValidationErrors, len(errs))
+	for i, fe := range errs {
+		ve[i] = FieldError{
+			Field:   fe.Field(),
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: translate(fe),
+		}
+	}
+	return ve
+}
+
+// translate picks the most specific message there is for the failed rule.
+func translate(fe validator.FieldError) string {
+	for _, key := range []string{fe.Tag() + "-" + kindName(fe), fe.Tag(), "default"} {
+		if msg, err := translator.T(key, fe.Field(), fe.Param()); err == nil {
+			return msg
+		}
+	}
+	return fe.(error).Error()
+}
+
+// kindName groups the kinds of field whose rules read differently.
+func kindName(fe validator.FieldError) string {
+	kind := fe.Kind()
+	if kind == reflect.Ptr {
+		kind = fe.Type().Elem().Kind()
+	}
+
+	switch kind {
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return "items"
+	}
+	return "number"
+}
@@ -0,0 +1,178 @@
+package models
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+)
+
+var (
+	interfaceType     = reflect.TypeOf((*interface{})(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// StripWriteOnlyFields returns a copy of v that serializes to JSON without any
+// struct field tagged `writeOnly:"true"`, e.g. a user's password. Write-only
+// fields can still be decoded from a request body, they just never make it
+// back out. Structs, slices and maps are walked recursively; values without any
+// write-only fields are returned as they are.
+func StripWriteOnlyFields(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return stripValue(reflect.ValueOf(v))
+}
+
+func stripValue(val reflect.Value) interface{} {
+	if !val.IsValid() {
+		return nil
+	}
+	if !hasWriteOnlyFields(val.Type(), map[reflect.Type]bool{}) {
+		return val.Interface()
+	}
+
+	switch val.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if val.IsNil() {
+			return nil
+		}
+		return stripValue(val.Elem())
+
+	case reflect.Slice, reflect.Array:
+		if val.Kind() == reflect.Slice && val.IsNil() {
+			return nil
+		}
+		stripped := make([]interface{}, val.Len())
+		for i := 0; i < val.Len(); i++ {
+			stripped[i] = stripValue(val.Index(i))
+		}
+		return stripped
+
+	case reflect.Map:
+		if val.IsNil() {
+			return nil
+		}
+		stripped := reflect.MakeMapWithSize(reflect.MapOf(val.Type().Key(), interfaceType), val.Len())
+		iter := val.MapRange()
+		for iter.Next() {
+			stripped.SetMapIndex(iter.Key(), interfaceValue(stripValue(iter.Value())))
+		}
+		return stripped.Interface()
+
+	case reflect.Struct:
+		fields, vals := collectFields(val)
+		stripped := reflect.New(reflect.StructOf(fields)).Elem()
+		for i, v := range vals {
+			stripped.Field(i).Set(v)
+		}
+		return stripped.Interface()
+	}
+
+	return val.Interface()
+}
+
+// collectFields returns the fields, and their values, of a struct type that
+// mirrors val minus its write-only fields. Fields that contain write-only
+// fields further down become interface{} fields holding stripped copies.
+// Embedded structs are flattened so that encoding/json still promotes their
+// fields, with the outer struct's fields taking precedence like json does.
+func collectFields(val reflect.Value) ([]reflect.StructField, []reflect.Value) {
+	typ := val.Type()
+	var fields []reflect.StructField
+	var vals []reflect.Value
+	var embedded []reflect.Value
+	taken := map[string]bool{}
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		if field.Tag.Get("writeOnly") == "true" {
+			continue
+		}
+
+		fv := val.Field(i)
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				embedded = append(embedded, fv)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		if hasWriteOnlyFields(field.Type, map[reflect.Type]bool{}) {
+			field.Type = interfaceType
+			fv = interfaceValue(stripValue(fv))
+		}
+
+		fields = append(fields, reflect.StructField{Name: field.Name, Type: field.Type, Tag: field.Tag})
+		vals = append(vals, fv)
+		taken[field.Name] = true
+	}
+
+	for _, ev := range embedded {
+		ef, evals := collectFields(ev)
+		for i, f := range ef {
+			if taken[f.Name] {
+				continue
+			}
+			fields = append(fields, f)
+			vals = append(vals, evals[i])
+			taken[f.Name] = true
+		}
+	}
+
+	return fields, vals
+}
+
+// hasWriteOnlyFields reports whether values of type t could contain write-only
+// fields. Interfaces can hold anything so they always need to be inspected.
+// Types that marshal themselves (e.g. time.Time) are left alone.
+func hasWriteOnlyFields(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) {
+		return false
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return hasWriteOnlyFields(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() && !field.Anonymous {
+				continue
+			}
+			if field.Tag.Get("writeOnly") == "true" || hasWriteOnlyFields(field.Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// interfaceValue wraps v in a reflect.Value of type interface{}, so that nil
+// values can still be assigned to struct fields and map entries.
+func interfaceValue(v interface{}) reflect.Value {
+	iv := reflect.New(interfaceType).Elem()
+	if v != nil {
+		iv.Set(reflect.ValueOf(v))
+	}
+	return iv
+}
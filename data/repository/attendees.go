@@ -0,0 +1,244 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"events-app/data/models"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrAlreadyAttending = errors.New("user has already RSVP'd to this event")
+	ErrNotAttending     = errors.New("user is not attending this event")
+)
+
+// RSVP registers the user for the event and returns the attendance record.
+// While the event has seats left (MaxAttendees of zero means unlimited) the
+// RSVP is confirmed; after that it joins the back of the waitlist. It returns
+// ErrAlreadyAttending if the user is already confirmed or waitlisted. A
+// previously cancelled RSVP is treated like a new one.
+//
+// The event's row is locked for the duration of the transaction, so concurrent
+// RSVPs to the same event queue up behind each other and can't both take the
+// last seat.
+func (sr *SqlRepo) RSVP(eventID, userID int64) (models.Attendee, error) {
+	return sr.RSVPContext(context.Background(), eventID, userID)
+}
+
+func (sr *SqlRepo) RSVPContext(ctx context.Context, eventID, userID int64) (models.Attendee, error) {
+	attendee := models.Attendee{}
+	err := sr.inTx(ctx, nil, func(tx *SqlRepo) error {
+		maxAttendees, err := tx.lockEvent(ctx, eventID)
+		if err != nil {
+			return err
+		}
+
+		current, err := tx.attendeeStatus(ctx, eventID, userID)
+		if err != nil {
+			return err
+		}
+		if current == models.AttendeeConfirmed || current == models.AttendeeWaitlisted {
+			return ErrAlreadyAttending
+		}
+
+		hasSeat, err := tx.seatAvailable(ctx, eventID, maxAttendees)
+		if err != nil {
+			return err
+		}
+		status := models.AttendeeConfirmed
+		if !hasSeat {
+			status = models.AttendeeWaitlisted
+		}
+
+		// created_at is reset on a repeat RSVP so it goes to the back of the queue
+		query := fmt.Sprintf(
+			`INSERT INTO attendees (event_id, user_id, status) VALUES ($1, $2, $3)
+			ON CONFLICT (event_id, user_id) DO UPDATE SET status = EXCLUDED.status, created_at = NOW()
+			RETURNING %s`,
+			strings.Join(models.GetColumnNames(attendee, false), ", "))
+
+		r := tx.conn().QueryRowContext(ctx, query, eventID, userID, status)
+		if err := models.ScanRowToModel(&attendee, r); err != nil {
+			return fmt.Errorf("error executing query: %w", dbError(&attendee, err))
+		}
+		return nil
+	})
+	if err != nil {
+		return models.Attendee{}, err
+	}
+	return attendee, nil
+}
+
+// CancelRSVP cancels the user's RSVP to the event, whether it was confirmed or
+// waitlisted. When a confirmed seat frees up, the first user on the waitlist is
+// promoted in the same transaction, along with any others there are free seats
+// for. It returns ErrNotAttending if the user has
+// no active RSVP.
+func (sr *SqlRepo) CancelRSVP(eventID, userID int64) error {
+	return sr.CancelRSVPContext(context.Background(), eventID, userID)
+}
+
+func (sr *SqlRepo) CancelRSVPContext(ctx context.Context, eventID, userID int64) error {
+	return sr.inTx(ctx, nil, func(tx *SqlRepo) error {
+		maxAttendees, err := tx.lockEvent(ctx, eventID)
+		if err != nil {
+			return err
+		}
+
+		current, err := tx.attendeeStatus(ctx, eventID, userID)
+		if err != nil {
+			return err
+		}
+		if current != models.AttendeeConfirmed && current != models.AttendeeWaitlisted {
+			return ErrNotAttending
+		}
+
+		_, err = tx.conn().ExecContext(ctx,
+			`UPDATE attendees SET status = $1 WHERE event_id = $2 AND user_id = $3`,
+			models.AttendeeCancelled, eventID, userID)
+		if err != nil {
+			return fmt.Errorf("error executing query: %w", err)
+		}
+
+		if current == models.AttendeeConfirmed {
+			return tx.promoteFromWaitlist(ctx, eventID, maxAttendees)
+		}
+		return nil
+	})
+}
+
+// PromoteWaitlist confirms as many of the event's waitlisted users as it has
+// free seats for, longest waiting first. It is for when seats open up other
+// than through a cancellation, e.g. because the event's capacity was raised.
+// It returns ErrNotFound if the event doesn't exist.
+func (sr *SqlRepo) PromoteWaitlist(eventID int64) error {
+	return sr.PromoteWaitlistContext(context.Background(), eventID)
+}
+
+func (sr *SqlRepo) PromoteWaitlistContext(ctx context.Context, eventID int64) error {
+	return sr.inTx(ctx, nil, func(tx *SqlRepo) error {
+		maxAttendees, err := tx.lockEvent(ctx, eventID)
+		if err != nil {
+			return err
+		}
+		return tx.promoteFromWaitlist(ctx, eventID, maxAttendees)
+	})
+}
+
+// WaitlistStatus returns the number of users waiting for a seat at the event,
+// and the user's 1-based position in that queue, or 0 if they aren't on it.
+func (sr *SqlRepo) WaitlistStatus(eventID, userID int64) (length, position int, err error) {
+	return sr.WaitlistStatusContext(context.Background(), eventID, userID)
+}
+
+func (sr *SqlRepo) WaitlistStatusContext(ctx context.Context, eventID, userID int64) (length, position int, err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	err = sr.conn().QueryRowContext(ctx,
+		`SELECT COUNT(*), COUNT(*) FILTER (
+			WHERE (created_at, id) <= (
+				SELECT created_at, id FROM attendees
+				WHERE event_id = $1 AND user_id = $2 AND status = $3
+			)
+		)
+		FROM attendees WHERE event_id = $1 AND status = $3`,
+		eventID, userID, models.AttendeeWaitlisted).Scan(&length, &position)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error executing query: %w", err)
+	}
+	return length, position, nil
+}
+
+func (sr *SqlRepo) QueryAttendees(queryParams map[string]string) ([]models.Attendee, error) {
+	return sr.QueryAttendeesContext(context.Background(), queryParams)
+}
+
+func (sr *SqlRepo) QueryAttendeesContext(ctx context.Context, queryParams map[string]string) ([]models.Attendee, error) {
+	results, err := sr.QueryModelContext(ctx, models.Attendee{}, queryParams)
+	if err != nil {
+		return nil, err
+	}
+	attendees, ok := results.(*[]models.Attendee)
+	if !ok {
+		return nil, fmt.Errorf("type assertion to *[]models.Attendee failed, got %T", results)
+	}
+
+	return *attendees, nil
+}
+
+// lockEvent takes a row lock on the event for the rest of the transaction and
+// returns its capacity. It returns ErrNotFound if the event doesn't exist.
+func (sr *SqlRepo) lockEvent(ctx context.Context, eventID int64) (maxAttendees int, err error) {
+	var max sql.NullInt64
+	err = sr.conn().QueryRowContext(ctx, `SELECT max_attendees FROM events WHERE id = $1 FOR UPDATE`, eventID).Scan(&max)
+	if err != nil {
+		return 0, dbError(models.Event{}, err)
+	}
+	return int(max.Int64), nil
+}
+
+// attendeeStatus returns the status of the user's RSVP to the event, or an
+// empty string if they have never RSVP'd.
+func (sr *SqlRepo) attendeeStatus(ctx context.Context, eventID, userID int64) (string, error) {
+	var status string
+	err := sr.conn().QueryRowContext(ctx,
+		`SELECT status FROM attendees WHERE event_id = $1 AND user_id = $2`,
+		eventID, userID).Scan(&status)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	return status, nil
+}
+
+// seatAvailable reports whether a new RSVP can be confirmed straight away.
+// Free seats go to those already waiting first, so newcomers only get one if
+// there are seats to spare once everyone on the waitlist has been seated.
+// Seats are handed out as soon as they free up (see promoteFromWaitlist), so
+// that is only the case when nobody is waiting.
+func (sr *SqlRepo) seatAvailable(ctx context.Context, eventID int64, maxAttendees int) (bool, error) {
+	var confirmed, waitlisted int
+	err := sr.conn().QueryRowContext(ctx,
+		`SELECT COUNT(*) FILTER (WHERE status = $2), COUNT(*) FILTER (WHERE status = $3)
+		FROM attendees WHERE event_id = $1`,
+		eventID, models.AttendeeConfirmed, models.AttendeeWaitlisted).Scan(&confirmed, &waitlisted)
+	if err != nil {
+		return false, fmt.Errorf("error executing query: %w", err)
+	}
+
+	return maxAttendees <= 0 || confirmed+waitlisted < maxAttendees, nil
+}
+
+// promoteFromWaitlist confirms waitlisted users, longest waiting first, until
+// the event's free seats are taken or nobody is left waiting.
+func (sr *SqlRepo) promoteFromWaitlist(ctx context.Context, eventID int64, maxAttendees int) error {
+	// A NULL limit is no limit, for events without one
+	var free sql.NullInt64
+	if maxAttendees > 0 {
+		var confirmed int
+		err := sr.conn().QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM attendees WHERE event_id = $1 AND status = $2`,
+			eventID, models.AttendeeConfirmed).Scan(&confirmed)
+		if err != nil {
+			return fmt.Errorf("error executing query: %w", err)
+		}
+		if confirmed >= maxAttendees {
+			return nil
+		}
+		free = sql.NullInt64{Int64: int64(maxAttendees - confirmed), Valid: true}
+	}
+
+	_, err := sr.conn().ExecContext(ctx,
+		`UPDATE attendees SET status = $1 WHERE id IN (
+			SELECT id FROM attendees WHERE event_id = $2 AND status = $3
+			ORDER BY created_at, id LIMIT $4
+		)`,
+		models.AttendeeConfirmed, eventID, models.AttendeeWaitlisted, free)
+	if err != nil {
+		return fmt.Errorf("error executing query: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"events-app/data/models"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+)
+
+// maxInsertParams is the most placeholders postgres accepts in one statement.
+const maxInsertParams = 65535
+
+// maxInsertRows caps the rows of one multi-row INSERT, so a batch of models
+// with few columns doesn't become one huge statement.
+const maxInsertRows = 1000
+
+// errCopyUnavailable is returned by copyMany when the connection doesn't go
+// through pgx, so CreateMany has to fall back to INSERTs.
+var errCopyUnavailable = errors.New("COPY needs a pgx connection")
+
+// CreateMany inserts models of one type into their table and returns the IDs
+// of the new records, in the order of the models. Either all of them are
+// inserted or none are.
+//
+// Outside a transaction, the models are written with COPY, which is far
+// faster than inserting them one at a time. In a transaction, or on a driver
+// other than pgx, they are written with multi-row INSERTs instead.
+func (sr *SqlRepo) CreateMany(ms []models.Model) ([]int64, error) {
+	return sr.CreateManyContext(context.Background(), ms)
+}
+
+func (sr *SqlRepo) CreateManyContext(ctx context.Context, ms []models.Model) (ids []int64, err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	if len(ms) == 0 {
+		return nil, nil
+	}
+	rows := make([][]interface{}, len(ms))
+	for i, m := range ms {
+		if reflect.TypeOf(m) != reflect.TypeOf(ms[0]) {
+			return nil, fmt.Errorf("expected every model to be a %T, got %T", ms[0], m)
+		}
+		if m, err = models.PrepareForWrite(m); err != nil {
+			return nil, err
+		}
+		rows[i] = models.GetValsFromModel(m)
+	}
+
+	if sr.tx == nil {
+		ids, err = sr.copyMany(ctx, ms[0], rows)
+		if !errors.Is(err, errCopyUnavailable) {
+			return ids, err
+		}
+	}
+
+	// Several INSERTs are only all-or-nothing in a transaction
+	err = sr.inTx(ctx, nil, func(tx *SqlRepo) error {
+		ids, err = tx.insertMany(ctx, ms[0], rows)
+		return err
+	})
+	return ids, err
+}
+
+// copyMany writes rows of m's columns with COPY. COPY doesn't return the IDs
+// it generates, so they are taken from the table's sequence beforehand and
+// written along with the rest.
+func (sr *SqlRepo) copyMany(ctx context.Context, m models.Model, rows [][]interface{}) ([]int64, error) {
+	c, err := sr.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var ids []int64
+	err = c.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errCopyUnavailable
+		}
+		conn := sc.Conn()
+
+		idRows, err := conn.Query(ctx,
+			`SELECT nextval(pg_get_serial_sequence($1, 'id')) FROM generate_series(1, $2)`,
+			m.TableName(), len(rows))
+		if err != nil {
+			return fmt.Errorf("error reserving ids: %w", err)
+		}
+		defer idRows.Close()
+		for idRows.Next() {
+			var id int64
+			if err := idRows.Scan(&id); err != nil {
+				return fmt.Errorf("error reserving ids: %w", err)
+			}
+			ids = append(ids, id)
+		}
+		// The connection is busy until the rows are closed
+		idRows.Close()
+		if err := idRows.Err(); err != nil {
+			return fmt.Errorf("error reserving ids: %w", err)
+		}
+
+		copyRows := make([][]interface{}, len(rows))
+		for i, vals := range rows {
+			copyRows[i] = append([]interface{}{ids[i]}, copyValues(vals)...)
+		}
+		columns := append([]string{"id"}, models.GetColumnNames(m, true)...)
+		if _, err := conn.CopyFrom(ctx, pgx.Identifier{m.TableName()}, columns, pgx.CopyFromRows(copyRows)); err != nil {
+			return fmt.Errorf("error copying rows: %w", dbError(m, err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// copyValues prepares values for COPY, which pgx encodes itself rather than
+// through their driver.Valuer. It writes a nil slice as NULL, where Value
+// might give an empty array, so nil slices are made empty.
+func copyValues(vals []interface{}) []interface{} {
+	out := make([]interface{}, len(vals))
+	for i, v := range vals {
+		out[i] = v
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.IsNil() {
+			out[i] = reflect.MakeSlice(rv.Type(), 0, 0).Interface()
+		}
+	}
+	return out
+}
+
+// insertMany writes rows of m's columns with as few INSERT statements as the
+// limit on placeholders allows.
+func (sr *SqlRepo) insertMany(ctx context.Context, m models.Model, rows [][]interface{}) ([]int64, error) {
+	columns := models.GetColumnNames(m, true)
+	batchSize := maxInsertParams / len(columns)
+	if batchSize > maxInsertRows {
+		batchSize = maxInsertRows
+	}
+
+	ids := make([]int64, 0, len(rows))
+	for start := 0; start < len(rows); start += batchSize {
+		end := start + batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		tuples := make([]string, 0, end-start)
+		vals := make([]interface{}, 0, (end-start)*len(columns))
+		for _, row := range rows[start:end] {
+			placeholders := make([]string, len(row))
+			for i, v := range row {
+				vals = append(vals, v)
+				placeholders[i] = fmt.Sprintf("$%d", len(vals))
+			}
+			tuples = append(tuples, "("+strings.Join(placeholders, ", ")+")")
+		}
+
+		// Postgres returns the ids in the order of the VALUES
+		query := fmt.Sprintf(
+			`INSERT INTO %s (%s) VALUES %s RETURNING id`,
+			m.TableName(),
+			strings.Join(columns, ", "),
+			strings.Join(tuples, ", "))
+		result, err := sr.conn().QueryContext(ctx, query, vals...)
+		if err != nil {
+			return nil, fmt.Errorf("error executing query: %w", dbError(m, err))
+		}
+		for result.Next() {
+			var id int64
+			if err := result.Scan(&id); err != nil {
+				result.Close()
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		result.Close()
+		if err := result.Err(); err != nil {
+			return nil, fmt.Errorf("error executing query: %w", dbError(m, err))
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+// SaveCalendarTokenHash sets the hash of the token that unlocks the user's
+// calendar feed, replacing any previous one so its URL stops working.
+func (sr *SqlRepo) SaveCalendarTokenHash(userID int64, hash string) error {
+	return sr.SaveCalendarTokenHashContext(context.Background(), userID, hash)
+}
+
+func (sr *SqlRepo) SaveCalendarTokenHashContext(ctx context.Context, userID int64, hash string) (err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	_, err = sr.conn().ExecContext(ctx,
+		`INSERT INTO calendar_feeds (user_id, token_hash) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET token_hash = EXCLUDED.token_hash, created_at = NOW()`,
+		userID, hash)
+	if err != nil {
+		return fmt.Errorf("error executing query: %w", dbError(nil, err))
+	}
+	return nil
+}
+
+// GetCalendarTokenHash returns the hash of the token that unlocks the user's
+// calendar feed. It returns ErrNotFound if the user has never asked for one.
+func (sr *SqlRepo) GetCalendarTokenHash(userID int64) (string, error) {
+	return sr.GetCalendarTokenHashContext(context.Background(), userID)
+}
+
+func (sr *SqlRepo) GetCalendarTokenHashContext(ctx context.Context, userID int64) (hash string, err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	err = sr.conn().QueryRowContext(ctx,
+		`SELECT token_hash FROM calendar_feeds WHERE user_id = $1`, userID).Scan(&hash)
+	if err != nil {
+		return "", dbError(nil, err)
+	}
+	return hash, nil
+}
@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"events-app/data/models"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// cursor is what a cursor token holds: the sort order of the query it was
+// made for, and the sort key and id of the last record of the page it ends.
+type cursor struct {
+	SortBy string `json:"s"`
+	Value  string `json:"v"`
+	ID     int64  `json:"i"`
+}
+
+var (
+	errInvalidCursor = errors.New("invalid cursor")
+	errNoCursorKey   = errors.New("cursors need a CursorKey")
+)
+
+// NextCursor returns a token for the cursor query parameter, which picks a
+// query up after last, the final record of the page it returned. Unlike an
+// offset, a cursor neither skips nor repeats records when others are added or
+// removed in the meantime, and seeking it costs the same however deep the page
+// is. It is only good for queries of the same table with the same sortBy.
+//
+// The token is encrypted with the repo's CursorKey, so clients can neither
+// read the sort key in it nor make up their own.
+func (sr *SqlRepo) NextCursor(last models.Model, queryParams map[string]string) (string, error) {
+	sortBy := sortKey(queryParams)
+	val := reflect.ValueOf(last)
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	if _, ok := models.MapQueryableJsonTagsToDB(last)[sortBy]; !ok {
+		return "", fmt.Errorf("%w: invalid sort value: %v", ErrInvalidQuery, sortBy)
+	}
+
+	c := cursor{SortBy: sortOrder(queryParams)}
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Type().Field(i)
+		switch field.Tag.Get("json") {
+		case "id":
+			c.ID = val.Field(i).Int()
+		case sortBy:
+			c.Value = cursorValue(val.Field(i))
+		}
+	}
+
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	aead, err := cursorCipher(sr.CursorKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	// The table is authenticated along with the cursor, so one made for a
+	// list of events can't be used on another model
+	sealed := aead.Seal(nonce, nonce, payload, []byte(last.TableName()))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// cursorCipher returns the cipher cursors are sealed with. Its key is derived
+// from the repo's CursorKey rather than being the key itself, so the same
+// secret can safely be used elsewhere, e.g. to sign access tokens.
+func cursorCipher(cursorKey []byte) (cipher.AEAD, error) {
+	if len(cursorKey) == 0 {
+		return nil, errNoCursorKey
+	}
+	mac := hmac.New(sha256.New, cursorKey)
+	mac.Write([]byte("events-app cursor"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// cursorValue writes a sort key the way postgres reads it back.
+func cursorValue(v reflect.Value) string {
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// sortOrder returns the query's sortBy parameter, which defaults to id.
+func sortOrder(queryParams map[string]string) string {
+	if sort := queryParams["sortBy"]; sort != "" {
+		return sort
+	}
+	return "id"
+}
+
+// sortKey returns the JSON name of the field a query is sorted on.
+func sortKey(queryParams map[string]string) string {
+	return strings.TrimPrefix(sortOrder(queryParams), "-")
+}
+
+// decodeCursor opens a cursor token made by NextCursor for m's table, making
+// sure it was made for a query sorted the same way.
+func decodeCursor(token string, m models.Model, queryParams map[string]string, cursorKey []byte) (cursor, error) {
+	aead, err := cursorCipher(cursorKey)
+	if err != nil {
+		return cursor{}, err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return cursor{}, errInvalidCursor
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	payload, err := aead.Open(nil, nonce, sealed, []byte(m.TableName()))
+	if err != nil {
+		return cursor{}, errInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return cursor{}, errInvalidCursor
+	}
+	if c.SortBy != sortOrder(queryParams) {
+		return cursor{}, fmt.Errorf("%w: it was made for a query sorted by %q", errInvalidCursor, c.SortBy)
+	}
+	return c, nil
+}
+
+// buildCursorClause builds the condition for the records after the cursor
+// parameter, if there is one: those whose sort key and id come after the
+// cursor's in the query's order, e.g. (start_date, id) > ($1, $2). With an
+// index on the sort column, postgres seeks straight to them. It returns an
+// empty string if there is no cursor.
+func buildCursorClause(queryParams map[string]string, m models.Model, cursorKey []byte, sort, order string, phIndex int, sqlVals []interface{}) (string, []interface{}, int, error) {
+	token, ok := queryParams["cursor"]
+	if !ok {
+		return "", sqlVals, phIndex, nil
+	}
+	if _, ok := queryParams["offset"]; ok {
+		return "", nil, 0, errors.New("cursor and offset can't be used together")
+	}
+	c, err := decodeCursor(token, m, queryParams, cursorKey)
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	operator := ">"
+	if order == "DESC" {
+		operator = "<"
+	}
+	if sort == "id" {
+		return fmt.Sprintf("id %s $%d", operator, phIndex), append(sqlVals, c.ID), phIndex + 1, nil
+	}
+	part := fmt.Sprintf("(%s, id) %s ($%d, $%d)", sort, operator, phIndex, phIndex+1)
+	return part, append(sqlVals, c.Value, c.ID), phIndex + 2, nil
+}
@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"events-app/data/models"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+)
+
+var (
+	// ErrNotFound is returned when the record asked for doesn't exist. Errors
+	// wrapping it also wrap sql.ErrNoRows.
+	ErrNotFound = errors.New("record not found")
+	// ErrDuplicate is returned when a write would break a unique constraint.
+	// The error is a *DuplicateError naming the offending field.
+	ErrDuplicate = errors.New("duplicate record")
+	// ErrForeignKey is returned when a write refers to a record that doesn't
+	// exist, or a delete would leave other records referring to one that
+	// doesn't. The error is a *ForeignKeyError.
+	ErrForeignKey = errors.New("foreign key violation")
+	// ErrValidation is returned when the database rejects a value, e.g. a
+	// missing required field or one that fails a check constraint. The error
+	// is a *ValidationError with the problem with each field.
+	ErrValidation = errors.New("validation failed")
+	// ErrInvalidQuery is returned by QueryModel when the query parameters
+	// can't be turned into a valid query for the model.
+	ErrInvalidQuery = errors.New("invalid query")
+	// ErrInvalidCredentials is returned by VerifyCredentials when the email
+	// doesn't belong to a user or the password doesn't match.
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	// ErrConflict is returned by Update when a versioned model has been
+	// changed by someone else since it was read.
+	ErrConflict = errors.New("the record has been modified since it was read")
+	// ErrInvalidField is returned by UpdateColumns when asked to write a field
+	// the model doesn't have, or one that is read-only.
+	ErrInvalidField = errors.New("invalid field")
+)
+
+// DuplicateError reports a unique constraint violation. It matches
+// ErrDuplicate with errors.Is.
+type DuplicateError struct {
+	// Field is the JSON name of the field whose value is taken, or empty if
+	// it couldn't be worked out.
+	Field string
+	Err   error
+}
+
+func (e *DuplicateError) Error() string {
+	if e.Field == "" {
+		return "a record with the same values already exists"
+	}
+	return fmt.Sprintf("a record with this %s already exists", e.Field)
+}
+
+func (e *DuplicateError) Is(target error) bool { return target == ErrDuplicate }
+func (e *DuplicateError) Unwrap() error        { return e.Err }
+
+// ForeignKeyError reports a foreign key violation. It matches ErrForeignKey
+// with errors.Is.
+type ForeignKeyError struct {
+	// Field is the JSON name of the field holding the reference, or empty if
+	// the record being deleted is still referenced elsewhere.
+	Field string
+	Err   error
+}
+
+func (e *ForeignKeyError) Error() string {
+	if e.Field == "" {
+		return "the record is still referenced by other records"
+	}
+	return fmt.Sprintf("the record referred to by %s does not exist", e.Field)
+}
+
+func (e *ForeignKeyError) Is(target error) bool { return target == ErrForeignKey }
+func (e *ForeignKeyError) Unwrap() error        { return e.Err }
+
+// ValidationError reports values the database refused to store. It matches
+// ErrValidation with errors.Is.
+type ValidationError struct {
+	// Fields maps the JSON name of each invalid field to what is wrong with
+	// it.
+	Fields map[string]string
+	Err    error
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for f := range e.Fields {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return "invalid value for " + strings.Join(fields, ", ")
+}
+
+func (e *ValidationError) Is(target error) bool { return target == ErrValidation }
+func (e *ValidationError) Unwrap() error        { return e.Err }
+
+// dbError translates an error from the driver into one of the repository's
+// errors where there is one that fits, using m to turn column names into
+// field names. Other errors are returned as they are.
+func dbError(m models.Model, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch pgErr.Code {
+	case pgerrcode.UniqueViolation:
+		return &DuplicateError{Field: fieldName(m, keyColumn(pgErr.Detail)), Err: err}
+	case pgerrcode.ForeignKeyViolation:
+		// A delete's detail says the key "is still referenced from" the
+		// other table, an insert's that it "is not present in" this one
+		if strings.Contains(pgErr.Detail, "still referenced") {
+			return &ForeignKeyError{Err: err}
+		}
+		return &ForeignKeyError{Field: fieldName(m, keyColumn(pgErr.Detail)), Err: err}
+	case pgerrcode.NotNullViolation:
+		field := fieldName(m, pgErr.ColumnName)
+		return &ValidationError{Fields: map[string]string{field: "is required"}, Err: err}
+	case pgerrcode.CheckViolation:
+		// postgres names column constraints <table>_<column>_check
+		column := strings.TrimSuffix(strings.TrimPrefix(pgErr.ConstraintName, pgErr.TableName+"_"), "_check")
+		field := fieldName(m, column)
+		return &ValidationError{Fields: map[string]string{field: "is not an allowed value"}, Err: err}
+	case pgerrcode.InvalidTextRepresentation,
+		pgerrcode.InvalidDatetimeFormat,
+		pgerrcode.DatetimeFieldOverflow,
+		pgerrcode.NumericValueOutOfRange:
+		return fmt.Errorf("%w: %s", ErrInvalidQuery, pgErr.Message)
+	}
+	return err
+}
+
+// keyColumn pulls the column name out of the detail postgres gives for a key
+// violation, e.g. `Key (email)=(a@b.com) already exists.` gives "email". It
+// returns an empty string for keys made up of several columns.
+func keyColumn(detail string) string {
+	_, rest, ok := strings.Cut(detail, "Key (")
+	if !ok {
+		return ""
+	}
+	column, _, ok := strings.Cut(rest, ")=")
+	if !ok || strings.Contains(column, ",") {
+		return ""
+	}
+	return column
+}
+
+// fieldName returns the JSON name of the model's field stored in column, or
+// the column name itself if the model doesn't have one.
+func fieldName(m models.Model, column string) string {
+	if m == nil {
+		return column
+	}
+	for field, c := range models.MapJsonTagsToDB(m) {
+		if c == column {
+			return field
+		}
+	}
+	return column
+}
@@ -3,19 +3,26 @@ package repository
 import (
 	"events-app/data/models"
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// DefaultLimit is how many records a query returns when it isn't given a
+// limit.
+const DefaultLimit = 10
+
 // buildQuery constructs a formatted and parameterized sql string from the
 // given query parameters. It returns the finished sql string, and the values to be
 // passed alongside the query. It returns an error if any of the query
-// parameters fail to validate against the model's jsonMap.
-func buildQueryClauses(queryParams map[string]string, m models.Model) (clauses string, sqlVals []interface{}, err error) {
-	placeholderIndex := 1
-	jsonMap := models.MapJsonTagsToDB(m)
+// parameters fail to validate against the model's jsonMap, which leaves out
+// write-only fields; see models.MapQueryableJsonTagsToDB. A cursor parameter
+// is opened with cursorKey.
+func buildQueryClauses(queryParams map[string]string, m models.Model, cursorKey []byte) (clauses string, sqlVals []interface{}, err error) {
+	jsonMap := models.MapQueryableJsonTagsToDB(m)
 	// Filtering
-	whereClause, sqlVals, placeholderIndex, err := buildWhereClause(queryParams, placeholderIndex, jsonMap)
+	whereClause, sqlVals, placeholderIndex, err := buildFilterClause(queryParams, m, jsonMap)
 	if err != nil {
 		return "", nil, err
 	}
@@ -26,6 +33,22 @@ func buildQueryClauses(queryParams map[string]string, m models.Model) (clauses s
 		return "", nil, err
 	}
 	orderClause := fmt.Sprintf("ORDER BY %s %s", sort, order)
+	if sort != "id" {
+		// Records with the same sort key come in the same order every time,
+		// so pages neither overlap nor leave any out
+		orderClause += fmt.Sprintf(", id %s", order)
+	}
+
+	// Keyset pagination
+	cursorClause, sqlVals, placeholderIndex, err := buildCursorClause(queryParams, m, cursorKey, sort, order, placeholderIndex, sqlVals)
+	if err != nil {
+		return "", nil, err
+	}
+	if cursorClause != "" && whereClause != "" {
+		whereClause += " AND " + cursorClause
+	} else if cursorClause != "" {
+		whereClause = "WHERE " + cursorClause
+	}
 
 	// Pagination
 	limit, offset, err := buildPaginationClause(queryParams)
@@ -44,6 +67,17 @@ func buildQueryClauses(queryParams map[string]string, m models.Model) (clauses s
 	return clauses, sqlVals, nil
 }
 
+// buildFilterClause builds the WHERE clause for the filters in the query
+// parameters, with their times normalized; see normalizeTimeParams. It returns
+// the clause, its values, and the next placeholder index.
+func buildFilterClause(queryParams map[string]string, m models.Model, jsonMap map[string]string) (string, []interface{}, int, error) {
+	queryParams, err := normalizeTimeParams(queryParams, m, jsonMap)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	return buildWhereClause(queryParams, 1, jsonMap)
+}
+
 // buildWhereClause constructs a formatted and parameterized sql WHERE clause.
 // It returns the finished WHERE clause, the values to be ultimately passed
 // alongside the query, and the current placeholder count. If there are no
@@ -54,7 +88,17 @@ func buildWhereClause(queryParams map[string]string, phIndex int, jsonMap map[st
 
 	for key, value := range queryParams {
 		// Skip these for later handling
-		if key == "sortBy" || key == "limit" || key == "offset" {
+		if key == "sortBy" || key == "limit" || key == "offset" || key == "cursor" || key == "tz" {
+			continue
+		}
+
+		if key == "during" {
+			var part string
+			part, sqlVals, phIndex, err = handleDuringParam(value, phIndex, sqlVals, jsonMap)
+			if err != nil {
+				return "", nil, 0, err
+			}
+			whereClauseParts = append(whereClauseParts, part)
 			continue
 		}
 
@@ -165,6 +209,140 @@ func handleInOperator(key, value string, phIndex int, whereClauseParts []string,
 	return whereClauseParts, sqlVals, phIndex, nil
 }
 
+// handleDuringParam builds a WHERE clause part matching records that take place
+// at any time within an interval, e.g. during=2024-06-01,2024-06-30 finds
+// events that start before the end of June and end after it starts. Both ends
+// of the interval are inclusive. It only works for models with a startDate and
+// an endDate.
+func handleDuringParam(value string, phIndex int, sqlVals []interface{}, jsonMap map[string]string) (string, []interface{}, int, error) {
+	startColumn, hasStart := jsonMap["startDate"]
+	endColumn, hasEnd := jsonMap["endDate"]
+	if !hasStart || !hasEnd {
+		return "", nil, 0, fmt.Errorf("invalid query parameter: during")
+	}
+
+	from, to, ok := strings.Cut(value, ",")
+	if !ok || from == "" || to == "" || strings.Contains(to, ",") {
+		return "", nil, 0, fmt.Errorf("during must be two comma-separated times, got %q", value)
+	}
+
+	part := fmt.Sprintf("%s <= $%d AND %s >= $%d", startColumn, phIndex, endColumn, phIndex+1)
+	// The end date of a recurring row is only that of its first occurrence,
+	// so those match on their start alone and are expanded afterwards
+	if rruleColumn, ok := jsonMap["rrule"]; ok {
+		part = fmt.Sprintf("%s <= $%d AND (%s >= $%d OR %s <> '')", startColumn, phIndex, endColumn, phIndex+1, rruleColumn)
+	}
+	sqlVals = append(sqlVals, to, from)
+	return part, sqlVals, phIndex + 2, nil
+}
+
+// timeParamLayouts are the formats accepted for times in query parameters.
+// Only the first carries an offset.
+var timeParamLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+// normalizeTimeParams rewrites the values of filters on time columns, e.g.
+// startDate_gte or during, as RFC 3339 times with an explicit offset, so the
+// database reads them the way the client meant them. Times given without an
+// offset are taken to be in the zone named by the tz parameter, or UTC. It
+// returns a new map; the one given is left alone.
+func normalizeTimeParams(queryParams map[string]string, m models.Model, jsonMap map[string]string) (map[string]string, error) {
+	loc, err := paramLocation(queryParams)
+	if err != nil {
+		return nil, err
+	}
+
+	timeColumns := make(map[string]bool)
+	typ := reflect.TypeOf(m)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		if typ.Field(i).Type == reflect.TypeOf(time.Time{}) {
+			timeColumns[typ.Field(i).Tag.Get("db")] = true
+		}
+	}
+
+	normalized := make(map[string]string, len(queryParams))
+	for key, value := range queryParams {
+		normalized[key] = value
+
+		var column string
+		switch key {
+		case "sortBy", "limit", "offset", "cursor", "tz":
+			continue
+		case "during":
+			column = jsonMap["startDate"]
+		default:
+			// Unknown keys are reported by buildWhereClause
+			_, column, _, _ = parseOperatorAndKey(key, value, jsonMap)
+		}
+		if !timeColumns[column] {
+			continue
+		}
+
+		times := strings.Split(value, ",")
+		for i, v := range times {
+			t, err := parseTimeParam(v, loc)
+			if err != nil {
+				return nil, err
+			}
+			times[i] = t.Format(time.RFC3339Nano)
+		}
+		normalized[key] = strings.Join(times, ",")
+	}
+	return normalized, nil
+}
+
+// paramLocation returns the zone named by the tz parameter, or UTC.
+func paramLocation(queryParams map[string]string) (*time.Location, error) {
+	tz, ok := queryParams["tz"]
+	if !ok {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil || tz == "Local" {
+		return nil, fmt.Errorf("invalid time zone: %s", tz)
+	}
+	return loc, nil
+}
+
+// duringWindow returns the interval given by the during parameter.
+func duringWindow(queryParams map[string]string) (from, to time.Time, err error) {
+	value, ok := queryParams["during"]
+	if !ok {
+		return time.Time{}, time.Time{}, fmt.Errorf("during is required")
+	}
+	start, end, ok := strings.Cut(value, ",")
+	if !ok || start == "" || end == "" || strings.Contains(end, ",") {
+		return time.Time{}, time.Time{}, fmt.Errorf("during must be two comma-separated times, got %q", value)
+	}
+
+	loc, err := paramLocation(queryParams)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if from, err = parseTimeParam(start, loc); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if to, err = parseTimeParam(end, loc); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return from, to, nil
+}
+
+// parseTimeParam parses a time from a query parameter. An unescaped "+" in
+// the offset arrives as a space once the query string is decoded, so it is
+// put back first.
+func parseTimeParam(value string, loc *time.Location) (time.Time, error) {
+	value = strings.ReplaceAll(value, " ", "+")
+	for _, layout := range timeParamLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected e.g. 2024-06-01T18:00:00+02:00", value)
+}
+
 func buildSortingClause(queryParams map[string]string, jsonMap map[string]string) (string, string, error) {
 	sort := queryParams["sortBy"]
 	order := "ASC"
@@ -185,20 +363,20 @@ func buildSortingClause(queryParams map[string]string, jsonMap map[string]string
 }
 
 func buildPaginationClause(queryParams map[string]string) (int, int, error) {
-	limit := 10
+	limit := DefaultLimit
 	offset := 0
 	if l, ok := queryParams["limit"]; ok {
 		var err error
 		limit, err = strconv.Atoi(l)
 		if err != nil {
-			return 0, 0, fmt.Errorf("pagination err; limit must be a number: %v", err)
+			return 0, 0, fmt.Errorf("pagination err; limit must be a number: %w", err)
 		}
 	}
 	if o, ok := queryParams["offset"]; ok {
 		var err error
 		offset, err = strconv.Atoi(o)
 		if err != nil {
-			return 0, 0, fmt.Errorf("pagination err; offset must be a number: %v", err)
+			return 0, 0, fmt.Errorf("pagination err; offset must be a number: %w", err)
 		}
 	}
 	return limit, offset, nil
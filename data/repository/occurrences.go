@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"events-app/data/models"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxOccurrenceWindow is the longest interval occurrences can be listed for.
+const MaxOccurrenceWindow = 366 * 24 * time.Hour
+
+// MaxOccurrenceEvents is the most events occurrences can be listed from at
+// once. Each has to be read and expanded whichever page is asked for, so a
+// window with more is turned away rather than expanding them all.
+const MaxOccurrenceEvents = 500
+
+// QueryOccurrences lists the occurrences of the events matching queryParams
+// that overlap the interval given by its during parameter, e.g.
+// during=2024-06-01,2024-06-30, which can be at most MaxOccurrenceWindow long.
+// Recurring events are expanded into an occurrence per repetition, with their
+// overrides applied; other events are a single occurrence. Occurrences are
+// sorted by start, then event, and the limit and offset parameters page
+// through them rather than through events. It also returns how many
+// occurrences there are in all. It returns ErrInvalidQuery if more than
+// MaxOccurrenceEvents events match.
+func (sr *SqlRepo) QueryOccurrences(queryParams map[string]string) ([]models.Occurrence, int, error) {
+	return sr.QueryOccurrencesContext(context.Background(), queryParams)
+}
+
+func (sr *SqlRepo) QueryOccurrencesContext(ctx context.Context, queryParams map[string]string) (_ []models.Occurrence, _ int, err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	from, to, err := duringWindow(queryParams)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+	}
+	if to.Before(from) || to.Sub(from) > MaxOccurrenceWindow {
+		return nil, 0, fmt.Errorf("%w: during must end after it starts, and span at most %d days",
+			ErrInvalidQuery, MaxOccurrenceWindow/(24*time.Hour))
+	}
+	if _, ok := queryParams["cursor"]; ok {
+		return nil, 0, fmt.Errorf("%w: occurrences can't be listed after a cursor", ErrInvalidQuery)
+	}
+	limit, offset, err := buildPaginationClause(queryParams)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+	}
+
+	events, err := sr.queryEventsDuring(ctx, queryParams)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var recurring []int64
+	for _, e := range events {
+		if e.RRule != "" {
+			recurring = append(recurring, e.ID)
+		}
+	}
+	overrides, err := sr.QueryOccurrenceOverridesContext(ctx, recurring)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	occurrences := []models.Occurrence{}
+	for _, e := range events {
+		expanded, err := e.Occurrences(from, to, overrides[e.ID])
+		if errors.Is(err, models.ErrTooManyOccurrences) {
+			return nil, 0, fmt.Errorf("%w: event %d has %w in the window; narrow it", ErrInvalidQuery, e.ID, err)
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("error expanding event %d: %w", e.ID, err)
+		}
+		occurrences = append(occurrences, expanded...)
+	}
+
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		if !occurrences[i].StartDate.Equal(occurrences[j].StartDate) {
+			return occurrences[i].StartDate.Before(occurrences[j].StartDate)
+		}
+		return occurrences[i].ID < occurrences[j].ID
+	})
+
+	total := len(occurrences)
+	start := min(max(offset, 0), total)
+	end := min(start+max(limit, 0), total)
+	return occurrences[start:end], total, nil
+}
+
+// queryEventsDuring returns every event matching queryParams' filters,
+// including during. Until they are expanded it isn't known which hold the
+// occurrences of a page, so they aren't paged through; instead there can be
+// at most MaxOccurrenceEvents of them, and more is an ErrInvalidQuery.
+func (sr *SqlRepo) queryEventsDuring(ctx context.Context, queryParams map[string]string) ([]models.Event, error) {
+	m := models.Event{}
+	whereClause, values, phIndex, err := buildFilterClause(queryParams, m, models.MapQueryableJsonTagsToDB(m))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+	}
+	// One more than the cap, to tell whether it was gone over
+	query := fmt.Sprintf(`SELECT %s FROM %s %s ORDER BY id LIMIT $%d`,
+		strings.Join(models.GetColumnNames(m, false), ", "), m.TableName(), whereClause, phIndex)
+	values = append(values, MaxOccurrenceEvents+1)
+
+	rows, err := sr.conn().QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, dbError(m, err)
+	}
+	defer rows.Close()
+
+	results, err := models.ScanRowsToSliceOfModels(m, rows, DefaultLimit)
+	if err != nil {
+		return nil, dbError(m, err)
+	}
+	events := *results.(*[]models.Event)
+	if len(events) > MaxOccurrenceEvents {
+		return nil, fmt.Errorf("%w: more than %d events take place during the window; narrow it or filter them",
+			ErrInvalidQuery, MaxOccurrenceEvents)
+	}
+	return events, nil
+}
+
+// QueryOccurrenceOverrides returns the overrides of the given events, by
+// event.
+func (sr *SqlRepo) QueryOccurrenceOverrides(eventIDs []int64) (map[int64][]models.OccurrenceOverride, error) {
+	return sr.QueryOccurrenceOverridesContext(context.Background(), eventIDs)
+}
+
+func (sr *SqlRepo) QueryOccurrenceOverridesContext(ctx context.Context, eventIDs []int64) (_ map[int64][]models.OccurrenceOverride, err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	overrides := make(map[int64][]models.OccurrenceOverride)
+	if len(eventIDs) == 0 {
+		return overrides, nil
+	}
+
+	placeholders := make([]string, len(eventIDs))
+	vals := make([]interface{}, len(eventIDs))
+	for i, id := range eventIDs {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		vals[i] = id
+	}
+	m := models.OccurrenceOverride{}
+	query := fmt.Sprintf(
+		`SELECT %s FROM %s WHERE event_id IN (%s)`,
+		strings.Join(models.GetColumnNames(m, false), ", "),
+		m.TableName(),
+		strings.Join(placeholders, ","))
+
+	rows, err := sr.conn().QueryContext(ctx, query, vals...)
+	if err != nil {
+		return nil, dbError(m, err)
+	}
+	defer rows.Close()
+
+	results, err := models.ScanRowsToSliceOfModels(m, rows, len(eventIDs))
+	if err != nil {
+		return nil, dbError(m, err)
+	}
+	for _, o := range *results.(*[]models.OccurrenceOverride) {
+		overrides[o.EventID] = append(overrides[o.EventID], o)
+	}
+	return overrides, nil
+}
+
+// SaveOccurrenceOverride cancels or moves an occurrence of a recurring event,
+// replacing any override the occurrence already has, and returns the stored
+// override. Callers should check the occurrence exists with
+// Event.IsOccurrence first.
+func (sr *SqlRepo) SaveOccurrenceOverride(o models.OccurrenceOverride) (models.OccurrenceOverride, error) {
+	return sr.SaveOccurrenceOverrideContext(context.Background(), o)
+}
+
+func (sr *SqlRepo) SaveOccurrenceOverrideContext(ctx context.Context, o models.OccurrenceOverride) (_ models.OccurrenceOverride, err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	query := fmt.Sprintf(
+		`INSERT INTO %s (event_id, original_start, cancelled, start_date, end_date) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (event_id, original_start) DO UPDATE
+		SET cancelled = EXCLUDED.cancelled, start_date = EXCLUDED.start_date, end_date = EXCLUDED.end_date
+		RETURNING %s`,
+		o.TableName(),
+		strings.Join(models.GetColumnNames(o, false), ", "))
+
+	saved := models.OccurrenceOverride{}
+	r := sr.conn().QueryRowContext(ctx, query, o.EventID, o.OriginalStart, o.Cancelled, o.StartDate, o.EndDate)
+	if err := models.ScanRowToModel(&saved, r); err != nil {
+		return models.OccurrenceOverride{}, fmt.Errorf("error executing query: %w", dbError(&saved, err))
+	}
+	return saved, nil
+}
+
+// DeleteOccurrenceOverride restores an occurrence to what the event's rule
+// makes it. It returns ErrNotFound if the occurrence wasn't overridden.
+func (sr *SqlRepo) DeleteOccurrenceOverride(eventID int64, originalStart time.Time) error {
+	return sr.DeleteOccurrenceOverrideContext(context.Background(), eventID, originalStart)
+}
+
+func (sr *SqlRepo) DeleteOccurrenceOverrideContext(ctx context.Context, eventID int64, originalStart time.Time) (err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	m := models.OccurrenceOverride{}
+	res, err := sr.conn().ExecContext(ctx,
+		fmt.Sprintf(`DELETE FROM %s WHERE event_id = $1 AND original_start = $2`, m.TableName()),
+		eventID, originalStart)
+	if err != nil {
+		return fmt.Errorf("error deleting record: %w", dbError(m, err))
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return dbError(m, sql.ErrNoRows)
+	}
+	return nil
+}
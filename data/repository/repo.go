@@ -1,7 +1,9 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"events-app/data/models"
 	"fmt"
 	"log"
@@ -9,27 +11,122 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/pgx"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// dummyHash is compared against when no user matches an email, so that a
+// failed login takes about as long whether or not the email exists.
+var dummyHash = func() string {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+	return string(hash)
+}()
+
+// DBRepo is the data access layer. Every method that talks to the database
+// has a variant with a Context suffix that takes a context.Context; the
+// variants without one use context.Background().
 type DBRepo interface {
 	Connection() *sql.DB
 	RunMigrations(dbName string) error
 	Create(m models.Model) (id int64, err error)
+	CreateContext(ctx context.Context, m models.Model) (id int64, err error)
+	CreateMany(ms []models.Model) ([]int64, error)
+	CreateManyContext(ctx context.Context, ms []models.Model) ([]int64, error)
 	Update(m models.Model) error
+	UpdateContext(ctx context.Context, m models.Model) error
+	UpdateColumns(m models.Model, fields []string) error
+	UpdateColumnsContext(ctx context.Context, m models.Model, fields []string) error
 	Delete(m models.Model) error
+	DeleteContext(ctx context.Context, m models.Model) error
 	GetModelByID(m models.Model, id int64) (models.Model, error)
+	GetModelByIDContext(ctx context.Context, m models.Model, id int64) (models.Model, error)
 	GetUserByID(id int64) (models.User, error)
+	GetUserByIDContext(ctx context.Context, id int64) (models.User, error)
+	GetUserByEmail(email string) (models.User, error)
+	GetUserByEmailContext(ctx context.Context, email string) (models.User, error)
+	VerifyCredentials(email, password string) (models.User, error)
+	VerifyCredentialsContext(ctx context.Context, email, password string) (models.User, error)
+	GetRefreshTokenByHash(hash string) (models.RefreshToken, error)
+	GetRefreshTokenByHashContext(ctx context.Context, hash string) (models.RefreshToken, error)
+	RevokeRefreshToken(hash string) (models.RefreshToken, error)
+	RevokeRefreshTokenContext(ctx context.Context, hash string) (models.RefreshToken, error)
 	GetEventByID(id int64) (models.Event, error)
+	GetEventByIDContext(ctx context.Context, id int64) (models.Event, error)
+	GetEventIDsByUID(userID int64, uids []string) (map[string]int64, error)
+	GetEventIDsByUIDContext(ctx context.Context, userID int64, uids []string) (map[string]int64, error)
 	QueryModel(m models.Model, queryParams map[string]string) (interface{}, error)
+	QueryModelContext(ctx context.Context, m models.Model, queryParams map[string]string) (interface{}, error)
+	CountModel(m models.Model, queryParams map[string]string) (int, error)
+	CountModelContext(ctx context.Context, m models.Model, queryParams map[string]string) (int, error)
+	NextCursor(last models.Model, queryParams map[string]string) (string, error)
 	QueryEvents(queryParams map[string]string) ([]models.Event, error)
+	QueryEventsContext(ctx context.Context, queryParams map[string]string) ([]models.Event, error)
+	QueryUserEvents(userID int64, queryParams map[string]string) ([]models.Event, error)
+	QueryUserEventsContext(ctx context.Context, userID int64, queryParams map[string]string) ([]models.Event, error)
+	QueryOccurrences(queryParams map[string]string) ([]models.Occurrence, int, error)
+	QueryOccurrencesContext(ctx context.Context, queryParams map[string]string) ([]models.Occurrence, int, error)
+	SaveOccurrenceOverride(o models.OccurrenceOverride) (models.OccurrenceOverride, error)
+	SaveOccurrenceOverrideContext(ctx context.Context, o models.OccurrenceOverride) (models.OccurrenceOverride, error)
+	DeleteOccurrenceOverride(eventID int64, originalStart time.Time) error
+	DeleteOccurrenceOverrideContext(ctx context.Context, eventID int64, originalStart time.Time) error
+	QueryOccurrenceOverrides(eventIDs []int64) (map[int64][]models.OccurrenceOverride, error)
+	QueryOccurrenceOverridesContext(ctx context.Context, eventIDs []int64) (map[int64][]models.OccurrenceOverride, error)
+	SaveCalendarTokenHash(userID int64, hash string) error
+	SaveCalendarTokenHashContext(ctx context.Context, userID int64, hash string) error
+	GetCalendarTokenHash(userID int64) (string, error)
+	GetCalendarTokenHashContext(ctx context.Context, userID int64) (string, error)
+	RSVP(eventID, userID int64) (models.Attendee, error)
+	RSVPContext(ctx context.Context, eventID, userID int64) (models.Attendee, error)
+	CancelRSVP(eventID, userID int64) error
+	CancelRSVPContext(ctx context.Context, eventID, userID int64) error
+	PromoteWaitlist(eventID int64) error
+	PromoteWaitlistContext(ctx context.Context, eventID int64) error
+	WaitlistStatus(eventID, userID int64) (length, position int, err error)
+	WaitlistStatusContext(ctx context.Context, eventID, userID int64) (length, position int, err error)
+	QueryAttendees(queryParams map[string]string) ([]models.Attendee, error)
+	QueryAttendeesContext(ctx context.Context, queryParams map[string]string) ([]models.Attendee, error)
+	WithTx(ctx context.Context, fn func(tx DBRepo) error) error
+	WithTxOptions(ctx context.Context, opts *sql.TxOptions, fn func(tx DBRepo) error) error
 }
 
 type SqlRepo struct {
 	DB *sql.DB
+	// QueryTimeout bounds each call made with a context that has no deadline
+	// of its own. Zero means no limit.
+	QueryTimeout time.Duration
+	// MaxTxRetries is how many times WithTx re-runs a transaction that failed
+	// because of a serialization failure or deadlock.
+	MaxTxRetries int
+	// CursorKey is the secret cursor tokens are encrypted with; see
+	// NextCursor. Queries can't be paged with cursors without one.
+	CursorKey []byte
+
+	// tx is set on the repos handed to WithTx callbacks
+	tx *sql.Tx
+}
+
+// withTimeout applies the repo's QueryTimeout to ctx, unless ctx already has a
+// deadline. The returned cancel func must always be called.
+func (sr *SqlRepo) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || sr.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, sr.QueryTimeout)
+}
+
+// contextError makes sure err wraps the context's error once ctx is done, so
+// callers can tell a timeout or cancellation apart from other failures. The
+// driver doesn't always wrap it itself; postgres, for one, reports a cancelled
+// statement as an error of its own.
+func contextError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil || errors.Is(err, ctx.Err()) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ctx.Err(), err)
 }
 
 func (sr *SqlRepo) Connection() *sql.DB {
@@ -51,16 +148,16 @@ func (sr *SqlRepo) RunMigrations(dbName string) error {
 
 	driver, err := pgx.WithInstance(sr.DB, &pgx.Config{})
 	if err != nil {
-		return fmt.Errorf("failed to create migration driver: %v", err)
+		return fmt.Errorf("failed to create migration driver: %w", err)
 	}
 
 	m, err := migrate.NewWithDatabaseInstance("file://"+migrationsDir, dbName, driver)
 	if err != nil {
-		return fmt.Errorf("failed to create migration instance: %v", err)
+		return fmt.Errorf("failed to create migration instance: %w", err)
 	}
 
 	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		return fmt.Errorf("failed to run migrations: %v", err)
+		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
 	log.Println("Migrations complete")
@@ -70,6 +167,19 @@ func (sr *SqlRepo) RunMigrations(dbName string) error {
 // Create inserts a model into the corresponding db table and returns id of the
 // newly created record.
 func (sr *SqlRepo) Create(m models.Model) (id int64, err error) {
+	return sr.CreateContext(context.Background(), m)
+}
+
+func (sr *SqlRepo) CreateContext(ctx context.Context, m models.Model) (id int64, err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	m, err = models.PrepareForWrite(m)
+	if err != nil {
+		return 0, err
+	}
+
 	vals := models.GetValsFromModel(m)
 	placeholders := make([]string, len(vals))
 	for i := 1; i <= len(vals); i++ {
@@ -82,58 +192,175 @@ func (sr *SqlRepo) Create(m models.Model) (id int64, err error) {
 		strings.Join(models.GetColumnNames(m, true), ", "),
 		strings.Join(placeholders, ", "))
 
-	stmt, err := sr.DB.Prepare(query)
+	stmt, err := sr.conn().PrepareContext(ctx, query)
 	if err != nil {
-		return 0, fmt.Errorf("error preparing query: %v", err)
+		return 0, fmt.Errorf("error preparing query: %w", err)
 	}
 	defer stmt.Close()
 
-	row := stmt.QueryRow(vals...)
+	row := stmt.QueryRowContext(ctx, vals...)
 	if err := row.Scan(&id); err != nil {
-		return 0, fmt.Errorf("error executing query: %v", err)
+		return 0, fmt.Errorf("error executing query: %w", dbError(m, err))
 	}
 
 	return id, nil
 }
 
+// Update writes the model's fields to its row. A versioned model (see
+// models.GetVersion) is only written if its version still matches the row's,
+// and the row's version is then incremented; otherwise Update returns
+// ErrConflict, or ErrNotFound if the row no longer exists.
 func (sr *SqlRepo) Update(m models.Model) error {
-	columns := models.GetColumnNames(m, true)
+	return sr.UpdateContext(context.Background(), m)
+}
 
+func (sr *SqlRepo) UpdateContext(ctx context.Context, m models.Model) (err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	m, err = models.PrepareForWrite(m)
+	if err != nil {
+		return err
+	}
+
+	return sr.update(ctx, m, models.GetColumnNames(m, true), models.GetValsFromModel(m))
+}
+
+// UpdateColumns is like Update, but only writes the fields named, by their
+// JSON tags, in fields; the rest of the row is left as it is. It returns an
+// error wrapping ErrInvalidField if a field doesn't exist or is read-only.
+func (sr *SqlRepo) UpdateColumns(m models.Model, fields []string) error {
+	return sr.UpdateColumnsContext(context.Background(), m, fields)
+}
+
+func (sr *SqlRepo) UpdateColumnsContext(ctx context.Context, m models.Model, fields []string) (err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	m, err = models.PrepareForWrite(m)
+	if err != nil {
+		return err
+	}
+
+	writable := models.GetColumnNames(m, true)
+	allVals := models.GetValsFromModel(m)
+	valsByColumn := make(map[string]interface{}, len(writable))
+	for i, c := range writable {
+		valsByColumn[c] = allVals[i]
+	}
+
+	jsonMap := models.MapJsonTagsToDB(m)
+	columns := make([]string, 0, len(fields))
+	vals := make([]interface{}, 0, len(fields))
+	for _, f := range fields {
+		val, ok := valsByColumn[jsonMap[f]]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrInvalidField, f)
+		}
+		columns = append(columns, jsonMap[f])
+		vals = append(vals, val)
+	}
+
+	if len(columns) == 0 {
+		return nil
+	}
+	return sr.update(ctx, m, columns, vals)
+}
+
+// update sets the given columns of the model's row to vals, checking and
+// bumping the version of versioned models.
+func (sr *SqlRepo) update(ctx context.Context, m models.Model, columns []string, vals []interface{}) error {
 	setClause := make([]string, (len(columns)))
 	for i, c := range columns {
 		setClause[i] = fmt.Sprintf("%s = $%d", c, i+1)
 	}
 
+	vals = append(vals, m.GetID())
+	whereClause := fmt.Sprintf("id = $%d", len(vals))
+
+	versionColumn, version, versioned := models.GetVersion(m)
+	if versioned {
+		setClause = append(setClause, fmt.Sprintf("%s = %s + 1", versionColumn, versionColumn))
+		vals = append(vals, version)
+		whereClause += fmt.Sprintf(" AND %s = $%d", versionColumn, len(vals))
+	}
+
 	query := fmt.Sprintf(
-		`UPDATE %s SET %s WHERE id = $%d`,
+		`UPDATE %s SET %s WHERE %s`,
 		m.TableName(),
 		strings.Join(setClause, ", "),
-		len(columns)+1)
+		whereClause)
 
-	stmt, err := sr.DB.Prepare(query)
+	stmt, err := sr.conn().PrepareContext(ctx, query)
 	if err != nil {
-		return fmt.Errorf("error preparing query: %v", err)
+		return fmt.Errorf("error preparing query: %w", err)
 	}
 	defer stmt.Close()
 
-	vals := models.GetValsFromModel(m)
-	vals = append(vals, m.GetID())
-	if _, err := stmt.Exec(vals...); err != nil {
-		return fmt.Errorf("error executing query: %v", err)
+	res, err := stmt.ExecContext(ctx, vals...)
+	if err != nil {
+		return fmt.Errorf("error executing query: %w", dbError(m, err))
+	}
+
+	if versioned {
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return sr.versionConflict(ctx, m)
+		}
 	}
 	return nil
 }
 
+// versionConflict works out why a versioned update matched no rows: either the
+// record is gone, or someone else has bumped its version.
+func (sr *SqlRepo) versionConflict(ctx context.Context, m models.Model) error {
+	var exists bool
+	query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE id = $1)`, m.TableName())
+	if err := sr.conn().QueryRowContext(ctx, query, m.GetID()).Scan(&exists); err != nil {
+		return fmt.Errorf("error executing query: %w", err)
+	}
+	if !exists {
+		return dbError(m, sql.ErrNoRows)
+	}
+	return ErrConflict
+}
+
+// Delete removes the model's row. As with Update, a versioned model is only
+// removed if its version still matches the row's; otherwise Delete returns
+// ErrConflict, or ErrNotFound if the row is already gone.
 func (sr *SqlRepo) Delete(m models.Model) error {
-	query := fmt.Sprintf("DELETE FROM %s WHERE id = $1", m.TableName())
-	stmt, err := sr.DB.Prepare(query)
+	return sr.DeleteContext(context.Background(), m)
+}
+
+func (sr *SqlRepo) DeleteContext(ctx context.Context, m models.Model) (err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	vals := []interface{}{m.GetID()}
+	whereClause := "id = $1"
+	versionColumn, version, versioned := models.GetVersion(m)
+	if versioned {
+		vals = append(vals, version)
+		whereClause += fmt.Sprintf(" AND %s = $2", versionColumn)
+	}
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s", m.TableName(), whereClause)
+	stmt, err := sr.conn().PrepareContext(ctx, query)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	if _, err = stmt.Exec(m.GetID()); err != nil {
-		return fmt.Errorf("error deleting record: %v", err)
+	res, err := stmt.ExecContext(ctx, vals...)
+	if err != nil {
+		return fmt.Errorf("error deleting record: %w", dbError(m, err))
+	}
+	if versioned {
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return sr.versionConflict(ctx, m)
+		}
 	}
 	return nil
 }
@@ -141,20 +368,32 @@ func (sr *SqlRepo) Delete(m models.Model) error {
 // GetModelByID retrieves a model from the db by its ID and returns it. The
 // model must be passed as a pointer to the desired model type.
 func (sr *SqlRepo) GetModelByID(m models.Model, id int64) (models.Model, error) {
+	return sr.GetModelByIDContext(context.Background(), m, id)
+}
+
+func (sr *SqlRepo) GetModelByIDContext(ctx context.Context, m models.Model, id int64) (_ models.Model, err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
 	query := fmt.Sprintf(
 		`SELECT %s FROM %s WHERE id = $1`,
 		strings.Join(models.GetColumnNames(m, false), ", "),
 		m.TableName())
 
-	r := sr.DB.QueryRow(query, id)
+	r := sr.conn().QueryRowContext(ctx, query, id)
 	if err := models.ScanRowToModel(m, r); err != nil {
-		return nil, err
+		return nil, dbError(m, err)
 	}
 	return m, nil
 }
 
 func (sr *SqlRepo) GetUserByID(id int64) (models.User, error) {
-	model, err := sr.GetModelByID(&models.User{}, id)
+	return sr.GetUserByIDContext(context.Background(), id)
+}
+
+func (sr *SqlRepo) GetUserByIDContext(ctx context.Context, id int64) (models.User, error) {
+	model, err := sr.GetModelByIDContext(ctx, &models.User{}, id)
 	if err != nil {
 		return models.User{}, err
 	}
@@ -167,8 +406,103 @@ func (sr *SqlRepo) GetUserByID(id int64) (models.User, error) {
 	return *user, nil
 }
 
+// getModelByColumn retrieves a single model from the db whose column matches
+// the given value. The model must be passed as a pointer to the desired model
+// type. Only use it with columns that have a unique constraint.
+func (sr *SqlRepo) getModelByColumn(ctx context.Context, m models.Model, column string, value interface{}) (err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	query := fmt.Sprintf(
+		`SELECT %s FROM %s WHERE %s = $1`,
+		strings.Join(models.GetColumnNames(m, false), ", "),
+		m.TableName(),
+		column)
+
+	r := sr.conn().QueryRowContext(ctx, query, value)
+	return dbError(m, models.ScanRowToModel(m, r))
+}
+
+func (sr *SqlRepo) GetUserByEmail(email string) (models.User, error) {
+	return sr.GetUserByEmailContext(context.Background(), email)
+}
+
+func (sr *SqlRepo) GetUserByEmailContext(ctx context.Context, email string) (models.User, error) {
+	user := models.User{}
+	if err := sr.getModelByColumn(ctx, &user, "email", email); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// VerifyCredentials returns the user with the given email if the password
+// matches their stored hash. It returns ErrInvalidCredentials if there is no
+// such user or the password is wrong.
+func (sr *SqlRepo) VerifyCredentials(email, password string) (models.User, error) {
+	return sr.VerifyCredentialsContext(context.Background(), email, password)
+}
+
+func (sr *SqlRepo) VerifyCredentialsContext(ctx context.Context, email, password string) (models.User, error) {
+	user, err := sr.GetUserByEmailContext(ctx, email)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			bcrypt.CompareHashAndPassword([]byte(dummyHash), []byte(password))
+			return models.User{}, ErrInvalidCredentials
+		}
+		return models.User{}, err
+	}
+
+	if !user.PasswordMatches(password) {
+		return models.User{}, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+func (sr *SqlRepo) GetRefreshTokenByHash(hash string) (models.RefreshToken, error) {
+	return sr.GetRefreshTokenByHashContext(context.Background(), hash)
+}
+
+func (sr *SqlRepo) GetRefreshTokenByHashContext(ctx context.Context, hash string) (models.RefreshToken, error) {
+	token := models.RefreshToken{}
+	if err := sr.getModelByColumn(ctx, &token, "token_hash", hash); err != nil {
+		return models.RefreshToken{}, err
+	}
+	return token, nil
+}
+
+// RevokeRefreshToken revokes the active refresh token with the given hash and
+// returns it. Checking and revoking the token is a single statement, so of two
+// requests racing to use it only one gets it back; the other, like any request
+// with a token that is unknown, revoked or expired, gets ErrNotFound.
+func (sr *SqlRepo) RevokeRefreshToken(hash string) (models.RefreshToken, error) {
+	return sr.RevokeRefreshTokenContext(context.Background(), hash)
+}
+
+func (sr *SqlRepo) RevokeRefreshTokenContext(ctx context.Context, hash string) (_ models.RefreshToken, err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	token := models.RefreshToken{}
+	query := fmt.Sprintf(
+		`UPDATE %s SET revoked = true WHERE token_hash = $1 AND NOT revoked AND expires_at > now() RETURNING %s`,
+		token.TableName(),
+		strings.Join(models.GetColumnNames(token, false), ", "))
+
+	r := sr.conn().QueryRowContext(ctx, query, hash)
+	if err := models.ScanRowToModel(&token, r); err != nil {
+		return models.RefreshToken{}, dbError(&token, err)
+	}
+	return token, nil
+}
+
 func (sr *SqlRepo) GetEventByID(id int64) (models.Event, error) {
-	model, err := sr.GetModelByID(&models.Event{}, id)
+	return sr.GetEventByIDContext(context.Background(), id)
+}
+
+func (sr *SqlRepo) GetEventByIDContext(ctx context.Context, id int64) (models.Event, error) {
+	model, err := sr.GetModelByIDContext(ctx, &models.Event{}, id)
 	if err != nil {
 		return models.Event{}, err
 	}
@@ -181,25 +515,93 @@ func (sr *SqlRepo) GetEventByID(id int64) (models.Event, error) {
 	return *event, nil
 }
 
+// GetEventIDsByUID looks up which of the given iCalendar UIDs the user's
+// events have, returning their IDs by UID.
+func (sr *SqlRepo) GetEventIDsByUID(userID int64, uids []string) (map[string]int64, error) {
+	return sr.GetEventIDsByUIDContext(context.Background(), userID, uids)
+}
+
+func (sr *SqlRepo) GetEventIDsByUIDContext(ctx context.Context, userID int64, uids []string) (_ map[string]int64, err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	ids := make(map[string]int64)
+	if len(uids) == 0 {
+		return ids, nil
+	}
+
+	placeholders := make([]string, len(uids))
+	vals := []interface{}{userID}
+	for i, uid := range uids {
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+		vals = append(vals, uid)
+	}
+	rows, err := sr.conn().QueryContext(ctx,
+		fmt.Sprintf(`SELECT uid, id FROM events WHERE user_id = $1 AND uid IN (%s)`, strings.Join(placeholders, ",")),
+		vals...)
+	if err != nil {
+		return nil, dbError(nil, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var uid string
+		var id int64
+		if err := rows.Scan(&uid, &id); err != nil {
+			return nil, dbError(nil, err)
+		}
+		ids[uid] = id
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError(nil, err)
+	}
+	return ids, nil
+}
+
 // QueryModel retrieves a slice of models from the db based on the provided
 // model and query parameters, and returns the slice as an interface{}. It
 // returns an error if the query params are invalid or if the query fails. If no
 // params are provided, it returns the first 10 records from the model's table
-// sorted by ID ascending.
+// sorted by ID ascending. A cursor parameter, from NextCursor, picks up after
+// the page it was made from, in place of an offset.
 func (sr *SqlRepo) QueryModel(m models.Model, queryParams map[string]string) (interface{}, error) {
-	clauses, values, err := buildQueryClauses(queryParams, m)
+	return sr.QueryModelContext(context.Background(), m, queryParams)
+}
+
+func (sr *SqlRepo) QueryModelContext(ctx context.Context, m models.Model, queryParams map[string]string) (interface{}, error) {
+	return sr.queryModel(ctx, m, queryParams, nil)
+}
+
+// querySource narrows down the rows QueryModel reads from, returning a
+// subquery to read them from instead of the model's table. Its placeholders
+// are numbered from first, after those of the query parameters.
+type querySource func(first int) (subquery string, vals []interface{})
+
+func (sr *SqlRepo) queryModel(ctx context.Context, m models.Model, queryParams map[string]string, source querySource) (_ interface{}, err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	clauses, values, err := buildQueryClauses(queryParams, m, sr.CursorKey)
 	if err != nil {
-		return nil, fmt.Errorf("invalid query: %v", err)
+		return nil, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+	}
+	from := m.TableName()
+	if source != nil {
+		subquery, vals := source(len(values) + 1)
+		from = fmt.Sprintf("(%s) AS %s", subquery, m.TableName())
+		values = append(values, vals...)
 	}
 	query := fmt.Sprintf(
 		`SELECT %s FROM %s %s`,
 		strings.Join(models.GetColumnNames(m, false), ", "),
-		m.TableName(),
+		from,
 		clauses)
 
-	rows, err := sr.DB.Query(query, values...)
+	rows, err := sr.conn().QueryContext(ctx, query, values...)
 	if err != nil {
-		return nil, err
+		return nil, dbError(m, err)
 	}
 	defer rows.Close()
 
@@ -208,14 +610,71 @@ func (sr *SqlRepo) QueryModel(m models.Model, queryParams map[string]string) (in
 	limit, _ := strconv.Atoi(queryParams["limit"])
 	results, err := models.ScanRowsToSliceOfModels(m, rows, limit)
 	if err != nil {
-		return nil, err
+		return nil, dbError(m, err)
 	}
 
 	return results, nil
 }
 
+// CountModel returns how many records of the model's table match the filters
+// in the query parameters, i.e. how many QueryModel would find without a
+// limit. Sorting and pagination parameters are ignored.
+func (sr *SqlRepo) CountModel(m models.Model, queryParams map[string]string) (int, error) {
+	return sr.CountModelContext(context.Background(), m, queryParams)
+}
+
+func (sr *SqlRepo) CountModelContext(ctx context.Context, m models.Model, queryParams map[string]string) (_ int, err error) {
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	whereClause, values, _, err := buildFilterClause(queryParams, m, models.MapQueryableJsonTagsToDB(m))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+	}
+	query := fmt.Sprintf(`SELECT COUNT(*) FROM %s %s`, m.TableName(), whereClause)
+
+	var total int
+	if err := sr.conn().QueryRowContext(ctx, query, values...).Scan(&total); err != nil {
+		return 0, dbError(m, err)
+	}
+	return total, nil
+}
+
 func (sr *SqlRepo) QueryEvents(queryParams map[string]string) ([]models.Event, error) {
-	results, err := sr.QueryModel(models.Event{}, queryParams)
+	return sr.QueryEventsContext(context.Background(), queryParams)
+}
+
+func (sr *SqlRepo) QueryEventsContext(ctx context.Context, queryParams map[string]string) ([]models.Event, error) {
+	results, err := sr.QueryModelContext(ctx, models.Event{}, queryParams)
+	if err != nil {
+		return nil, err
+	}
+	events, ok := results.(*[]models.Event)
+	if !ok {
+		return nil, fmt.Errorf("type assertion to *[]models.Event failed, got %T", results)
+	}
+
+	return *events, nil
+}
+
+// QueryUserEvents is QueryEvents narrowed down to the events the user owns or
+// is attending, confirmed or waitlisted.
+func (sr *SqlRepo) QueryUserEvents(userID int64, queryParams map[string]string) ([]models.Event, error) {
+	return sr.QueryUserEventsContext(context.Background(), userID, queryParams)
+}
+
+func (sr *SqlRepo) QueryUserEventsContext(ctx context.Context, userID int64, queryParams map[string]string) ([]models.Event, error) {
+	source := func(first int) (string, []interface{}) {
+		subquery := fmt.Sprintf(
+			`SELECT * FROM events WHERE user_id = $%d OR id IN (
+				SELECT event_id FROM attendees WHERE user_id = $%d AND status IN ($%d, $%d)
+			)`,
+			first, first, first+1, first+2)
+		return subquery, []interface{}{userID, models.AttendeeConfirmed, models.AttendeeWaitlisted}
+	}
+
+	results, err := sr.queryModel(ctx, models.Event{}, queryParams, source)
 	if err != nil {
 		return nil, err
 	}
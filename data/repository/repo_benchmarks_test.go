@@ -1,7 +1,10 @@
 package repository
 
 import (
+	"context"
 	"events-app/data/models"
+	"fmt"
+	"strconv"
 	"testing"
 
 	"github.com/brianvoe/gofakeit/v7"
@@ -18,18 +21,24 @@ func SeedDBforBenchmark(b *testing.B) {
 		b.Fatalf("Could not seed DB: %s", err)
 	}
 
-	for i := 0; i < 1000; i++ {
-		e := models.Event{
+	if _, err := testRepo.CreateMany(fakeEvents(1000)); err != nil {
+		b.Fatalf("Could not seed DB: %s", err)
+	}
+}
+
+// fakeEvents returns n events owned by the first user.
+func fakeEvents(n int) []models.Model {
+	events := make([]models.Model, n)
+	for i := range events {
+		events[i] = models.Event{
 			UserID:       1,
 			Name:         gofakeit.LoremIpsumSentence(4),
 			Description:  gofakeit.LoremIpsumSentence(15),
 			StartDate:    gofakeit.FutureDate(),
 			MaxAttendees: 75,
 		}
-		if _, err := testRepo.Create(e); err != nil {
-			b.Fatalf("Could not seed DB: %s", err)
-		}
 	}
+	return events
 }
 
 func BenchmarkCreate(b *testing.B) {
@@ -59,6 +68,56 @@ func BenchmarkCreate(b *testing.B) {
 	}
 }
 
+// BenchmarkCreateMany compares inserting a batch of events one at a time with
+// Create, with CreateMany's COPY, and with its multi-row INSERTs, which it
+// falls back to in a transaction.
+func BenchmarkCreateMany(b *testing.B) {
+	defer handleRecover("BenchmarkCreateMany")
+
+	u := models.User{
+		Email:    gofakeit.Email(),
+		Password: "password",
+	}
+	_, err := testRepo.Create(u)
+	if err != nil {
+		b.Fatalf("Could not seed DB: %s", err)
+	}
+
+	for _, n := range []int{10, 100, 1000} {
+		events := fakeEvents(n)
+
+		b.Run(fmt.Sprintf("Create/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, e := range events {
+					if _, err := testRepo.Create(e); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("CreateMany_Copy/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := testRepo.CreateMany(events); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("CreateMany_Insert/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				err := testRepo.WithTx(context.Background(), func(tx DBRepo) error {
+					_, err := tx.CreateMany(events)
+					return err
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 func BenchmarkQueryEvents_Limit1000(b *testing.B) {
 	defer handleRecover("BenchmarkQueryModel_1000")
 
@@ -131,3 +190,46 @@ func BenchmarkQueryEvents_Limit2000(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkQueryEvents_Depth compares reading a page deep into the events
+// sorted by start date with an offset, which postgres has to read and throw
+// away the rows before, with a cursor, which it seeks straight past.
+func BenchmarkQueryEvents_Depth(b *testing.B) {
+	defer handleRecover("BenchmarkQueryEvents_Depth")
+
+	SeedDBforBenchmark(b)
+	if _, err := testRepo.CreateMany(fakeEvents(20000)); err != nil {
+		b.Fatalf("Could not seed DB: %s", err)
+	}
+
+	for _, depth := range []int{100, 1000, 10000, 20000} {
+		offsetParams := map[string]string{"sortBy": "startDate", "limit": "10", "offset": strconv.Itoa(depth)}
+
+		// The cursor picks up after the event just before the page
+		before, err := testRepo.QueryEvents(map[string]string{"sortBy": "startDate", "limit": "1", "offset": strconv.Itoa(depth - 1)})
+		if err != nil || len(before) == 0 {
+			b.Fatalf("Could not find event %d: %v", depth, err)
+		}
+		cursorParams := map[string]string{"sortBy": "startDate", "limit": "10"}
+		cursorParams["cursor"], err = testRepo.NextCursor(before[0], cursorParams)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(fmt.Sprintf("Offset/%d", depth), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := testRepo.QueryEvents(offsetParams); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("Cursor/%d", depth), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := testRepo.QueryEvents(cursorParams); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,8 +1,15 @@
 package repository
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"events-app/data/models"
+	"expvar"
 	"log"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -18,6 +25,7 @@ func TestDBRepo(t *testing.T) {
 			Email:    "hello@example.com",
 			Password: "password",
 		}
+		assert.NoError(t, u.HashPassword())
 		id, err := testRepo.Create(u)
 
 		assert.NoError(t, err)
@@ -48,7 +56,76 @@ func TestDBRepo(t *testing.T) {
 		assert.Equal(t, "hello@example.com", u.Email)
 		assert.Equal(t, int64(1), u.ID)
 		assert.NotEmpty(t, u.Password)
+		assert.NotEqual(t, "password", u.Password)
+		assert.True(t, u.PasswordMatches("password"))
 		assert.NotEmpty(t, u.CreatedAt)
+		assert.Equal(t, models.RoleUser, u.Role)
+	})
+
+	t.Run("Test VerifyCredentials", func(t *testing.T) {
+		defer handleRecover(t.Name())
+
+		u, err := testRepo.VerifyCredentials("hello@example.com", "password")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), u.ID)
+
+		_, err = testRepo.VerifyCredentials("hello@example.com", "wrong password")
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+		_, err = testRepo.VerifyCredentials("nobody@example.com", "password")
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+
+	t.Run("Test GetRefreshTokenByHash", func(t *testing.T) {
+		defer handleRecover(t.Name())
+
+		rt := models.RefreshToken{
+			UserID:    1,
+			TokenHash: "abc123",
+			ExpiresAt: time.Now().UTC().Add(time.Hour),
+		}
+		_, err := testRepo.Create(rt)
+		assert.NoError(t, err)
+
+		stored, err := testRepo.GetRefreshTokenByHash("abc123")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), stored.UserID)
+		assert.True(t, stored.IsActive())
+
+		stored.Revoked = true
+		assert.NoError(t, testRepo.Update(stored))
+
+		stored, err = testRepo.GetRefreshTokenByHash("abc123")
+		assert.NoError(t, err)
+		assert.False(t, stored.IsActive())
+	})
+
+	t.Run("Test RevokeRefreshToken", func(t *testing.T) {
+		defer handleRecover(t.Name())
+
+		_, err := testRepo.Create(models.RefreshToken{
+			UserID:    1,
+			TokenHash: "def456",
+			ExpiresAt: time.Now().UTC().Add(time.Hour),
+		})
+		assert.NoError(t, err)
+
+		revoked, err := testRepo.RevokeRefreshToken("def456")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), revoked.UserID)
+
+		// A token can only be used once
+		_, err = testRepo.RevokeRefreshToken("def456")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		_, err = testRepo.Create(models.RefreshToken{
+			UserID:    1,
+			TokenHash: "expired",
+			ExpiresAt: time.Now().UTC().Add(-time.Hour),
+		})
+		assert.NoError(t, err)
+		_, err = testRepo.RevokeRefreshToken("expired")
+		assert.ErrorIs(t, err, ErrNotFound)
 	})
 
 	t.Run("Test GetEventByID", func(t *testing.T) {
@@ -83,6 +160,60 @@ func TestDBRepo(t *testing.T) {
 		assert.NoError(t, err)
 
 		assert.Equal(t, "newEmail@example.com", u.Email)
+		// the stored hash must survive an update that doesn't touch it
+		assert.True(t, u.PasswordMatches("password"))
+	})
+
+	t.Run("Test versioned Update", func(t *testing.T) {
+		defer handleRecover(t.Name())
+
+		e, err := testRepo.GetEventByID(1)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), e.Version)
+
+		stale := e
+		e.Name = "Renamed Test Event"
+		assert.NoError(t, testRepo.Update(e))
+
+		updated, err := testRepo.GetEventByID(1)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), updated.Version)
+
+		stale.Description = "Written from an out of date copy"
+		assert.ErrorIs(t, testRepo.Update(stale), ErrConflict)
+
+		missing := updated
+		missing.ID = 999999
+		assert.ErrorIs(t, testRepo.Update(missing), ErrNotFound)
+
+		updated.Name = "Test Event"
+		assert.NoError(t, testRepo.Update(updated))
+	})
+
+	t.Run("Test UpdateColumns", func(t *testing.T) {
+		defer handleRecover(t.Name())
+
+		e, err := testRepo.GetEventByID(1)
+		assert.NoError(t, err)
+
+		// Only the named column is written, the rest of the copy is ignored
+		patch := e
+		patch.Name = "Partially Updated Event"
+		patch.Description = "Never written"
+		assert.NoError(t, testRepo.UpdateColumns(patch, []string{"name"}))
+
+		updated, err := testRepo.GetEventByID(1)
+		assert.NoError(t, err)
+		assert.Equal(t, "Partially Updated Event", updated.Name)
+		assert.Equal(t, e.Description, updated.Description)
+		assert.Equal(t, e.Version+1, updated.Version)
+
+		assert.ErrorIs(t, testRepo.UpdateColumns(updated, []string{"createdAt"}), ErrInvalidField)
+		assert.ErrorIs(t, testRepo.UpdateColumns(updated, []string{"colour"}), ErrInvalidField)
+		assert.ErrorIs(t, testRepo.UpdateColumns(patch, []string{"name"}), ErrConflict)
+
+		updated.Name = "Test Event"
+		assert.NoError(t, testRepo.UpdateColumns(updated, []string{"name"}))
 	})
 
 	t.Run("Test unique constraint", func(t *testing.T) {
@@ -93,7 +224,302 @@ func TestDBRepo(t *testing.T) {
 			Password: "password",
 		}
 		_, err := testRepo.Create(u)
+		assert.ErrorIs(t, err, ErrDuplicate)
+
+		var dupErr *DuplicateError
+		if assert.ErrorAs(t, err, &dupErr) {
+			assert.Equal(t, "email", dupErr.Field)
+		}
+	})
+
+	t.Run("Test foreign key constraint", func(t *testing.T) {
+		defer handleRecover(t.Name())
+
+		e := models.Event{
+			UserID:      999999,
+			Name:        "Orphaned Event",
+			Description: "Belongs to nobody",
+			StartDate:   time.Now().Add(time.Hour * 24),
+		}
+		_, err := testRepo.Create(e)
+		assert.ErrorIs(t, err, ErrForeignKey)
+
+		var fkErr *ForeignKeyError
+		if assert.ErrorAs(t, err, &fkErr) {
+			assert.Equal(t, "userId", fkErr.Field)
+		}
+	})
+
+	t.Run("Test check constraint", func(t *testing.T) {
+		defer handleRecover(t.Name())
+
+		_, err := testRepo.Create(models.Attendee{EventID: 1, UserID: 1, Status: "maybe"})
+		assert.ErrorIs(t, err, ErrValidation)
+
+		var valErr *ValidationError
+		if assert.ErrorAs(t, err, &valErr) {
+			assert.Contains(t, valErr.Fields, "status")
+		}
+	})
+
+	t.Run("Test recurring events", func(t *testing.T) {
+		defer handleRecover(t.Name())
+
+		start := time.Now().Add(time.Hour * 24).Truncate(time.Second)
+		week := func(n int) time.Time { return start.AddDate(0, 0, 7*n) }
+		id, err := testRepo.Create(models.Event{
+			UserID:      1,
+			Name:        "Weekly Meetup",
+			Description: "Every week at the manor hotel",
+			StartDate:   start,
+			EndDate:     start.Add(time.Hour),
+			Timezone:    "Europe/Berlin",
+			RRule:       "RRULE:FREQ=WEEKLY;COUNT=10",
+			ExDates:     models.TimeList{week(2)},
+		})
+		assert.NoError(t, err)
+		defer deleteEvent(id)
+
+		e, err := testRepo.GetEventByID(id)
+		assert.NoError(t, err)
+		assert.Equal(t, "FREQ=WEEKLY;COUNT=10", e.RRule)
+		if assert.Len(t, e.ExDates, 1) {
+			assert.True(t, e.ExDates[0].Equal(week(2)))
+		}
+
+		_, err = testRepo.SaveOccurrenceOverride(models.OccurrenceOverride{
+			EventID: id, OriginalStart: week(1), Cancelled: true, StartDate: week(1), EndDate: week(1).Add(time.Hour),
+		})
+		assert.NoError(t, err)
+		moved, err := testRepo.SaveOccurrenceOverride(models.OccurrenceOverride{
+			EventID: id, OriginalStart: week(3), StartDate: week(3).Add(time.Hour), EndDate: week(3).Add(2 * time.Hour),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, id, moved.EventID)
+
+		// The window starts after the first occurrence has ended
+		during := week(0).Add(2*time.Hour).Format(time.RFC3339) + "," + week(4).Format(time.RFC3339)
+		occurrences, _, err := testRepo.QueryOccurrences(map[string]string{"name": "Weekly Meetup", "during": during})
+		assert.NoError(t, err)
+		var starts []time.Time
+		for _, o := range occurrences {
+			starts = append(starts, o.StartDate)
+		}
+		if assert.Len(t, starts, 2) {
+			assert.True(t, starts[0].Equal(week(3).Add(time.Hour)))
+			assert.True(t, starts[1].Equal(week(4)))
+		}
+
+		// Pages are of occurrences, not of events
+		occurrences, total, err := testRepo.QueryOccurrences(map[string]string{"name": "Weekly Meetup", "during": during, "limit": "1", "offset": "1"})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, total)
+		if assert.Len(t, occurrences, 1) {
+			assert.True(t, occurrences[0].StartDate.Equal(week(4)))
+		}
+
+		_, _, err = testRepo.QueryOccurrences(map[string]string{"during": "2024-01-01T00:00:00Z,2026-01-01T00:00:00Z"})
+		assert.ErrorIs(t, err, ErrInvalidQuery)
+
+		assert.NoError(t, testRepo.DeleteOccurrenceOverride(id, week(1)))
+		assert.ErrorIs(t, testRepo.DeleteOccurrenceOverride(id, week(1)), ErrNotFound)
+
+		_, err = testRepo.SaveOccurrenceOverride(models.OccurrenceOverride{
+			EventID: id, OriginalStart: week(5), StartDate: week(5), EndDate: week(5).Add(-time.Hour),
+		})
+		assert.ErrorIs(t, err, ErrValidation)
+	})
+
+	t.Run("Test calendar feeds", func(t *testing.T) {
+		defer handleRecover(t.Name())
+
+		_, err := testRepo.GetCalendarTokenHash(1)
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		assert.NoError(t, testRepo.SaveCalendarTokenHash(1, "first"))
+		assert.NoError(t, testRepo.SaveCalendarTokenHash(1, "second"))
+		hash, err := testRepo.GetCalendarTokenHash(1)
+		assert.NoError(t, err)
+		assert.Equal(t, "second", hash)
+
+		events, err := testRepo.QueryUserEvents(1, map[string]string{"name": "Test Event"})
+		assert.NoError(t, err)
+		assert.Len(t, events, 1)
+		events, err = testRepo.QueryUserEvents(2, map[string]string{"name": "Test Event"})
+		assert.NoError(t, err)
+		assert.Empty(t, events)
+	})
+
+	t.Run("Test GetEventIDsByUID", func(t *testing.T) {
+		defer handleRecover(t.Name())
+
+		imported := models.Event{
+			UserID:    1,
+			Name:      "Imported Event",
+			StartDate: time.Now().Add(time.Hour * 24),
+			UID:       "imported@example.com",
+		}
+		id, err := testRepo.Create(imported)
+		assert.NoError(t, err)
+		defer deleteEvent(id)
+
+		ids, err := testRepo.GetEventIDsByUID(1, []string{"imported@example.com", "missing@example.com"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int64{"imported@example.com": id}, ids)
+
+		// UIDs are only unique among one user's events
+		ids, err = testRepo.GetEventIDsByUID(2, []string{"imported@example.com"})
+		assert.NoError(t, err)
+		assert.Empty(t, ids)
+
+		_, err = testRepo.Create(imported)
+		assert.ErrorIs(t, err, ErrDuplicate)
+	})
+
+	t.Run("Test CreateMany", func(t *testing.T) {
+		defer handleRecover(t.Name())
+
+		newEvents := func(names ...string) []models.Model {
+			ms := make([]models.Model, len(names))
+			for i, name := range names {
+				e := models.Event{
+					UserID:      1,
+					Name:        name,
+					Description: "Created in bulk",
+					StartDate:   time.Now().Add(time.Hour * 24),
+				}
+				// Some with exception dates, some without
+				if i%2 == 1 {
+					e.ExDates = models.TimeList{e.StartDate.Add(time.Hour * 24)}
+				}
+				ms[i] = e
+			}
+			return ms
+		}
+
+		// With COPY, then with INSERTs in a transaction
+		copied, err := testRepo.CreateMany(newEvents("Bulk Event 1", "Bulk Event 2", "Bulk Event 3"))
+		assert.NoError(t, err)
+		var inserted []int64
+		err = testRepo.WithTx(context.Background(), func(tx DBRepo) error {
+			inserted, err = tx.CreateMany(newEvents("Bulk Event 4", "Bulk Event 5"))
+			return err
+		})
+		assert.NoError(t, err)
+
+		ids := append(copied, inserted...)
+		for i, id := range ids {
+			defer deleteEvent(id)
+			e, err := testRepo.GetEventByID(id)
+			assert.NoError(t, err)
+			assert.Equal(t, "Bulk Event "+strconv.Itoa(i+1), e.Name)
+		}
+		assert.Len(t, ids, 5)
+
+		_, err = testRepo.CreateMany([]models.Model{models.Event{}, models.User{}})
 		assert.Error(t, err)
+
+		// A failed row leaves none of them behind
+		bad := newEvents("Bulk Event 6", "Bulk Event 7")
+		bad[1] = models.Event{UserID: 999999, Name: "Bulk Event 7", Description: "Created in bulk", StartDate: time.Now().Add(time.Hour * 24)}
+		_, err = testRepo.CreateMany(bad)
+		assert.ErrorIs(t, err, ErrForeignKey)
+		events, err := testRepo.QueryEvents(map[string]string{"name": "Bulk Event 6"})
+		assert.NoError(t, err)
+		assert.Empty(t, events)
+	})
+
+	t.Run("Test write-only fields can't be queried", func(t *testing.T) {
+		defer handleRecover(t.Name())
+
+		for _, params := range []map[string]string{
+			{"password_gte": "$2a$"},
+			{"password": "password"},
+			{"sortBy": "password"},
+		} {
+			_, err := testRepo.QueryModel(models.User{}, params)
+			assert.ErrorIs(t, err, ErrInvalidQuery)
+			_, err = testRepo.CountModel(models.User{}, params)
+			if _, ok := params["sortBy"]; !ok {
+				assert.ErrorIs(t, err, ErrInvalidQuery)
+			}
+		}
+	})
+
+	t.Run("Test CountModel", func(t *testing.T) {
+		defer handleRecover(t.Name())
+
+		ids, err := testRepo.CreateMany(fakeEvents(3))
+		assert.NoError(t, err)
+		for _, id := range ids {
+			defer deleteEvent(id)
+		}
+
+		// Sorting and pagination don't change the count
+		anyOf := make([]string, len(ids))
+		for i, id := range ids {
+			anyOf[i] = strconv.FormatInt(id, 10)
+		}
+		params := map[string]string{"id_anyOf": strings.Join(anyOf, ","), "limit": "1", "offset": "1", "sortBy": "-name"}
+		total, err := testRepo.CountModel(models.Event{}, params)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, total)
+
+		params["id_ne"] = strconv.FormatInt(ids[0], 10)
+		total, err = testRepo.CountModel(models.Event{}, params)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, total)
+
+		_, err = testRepo.CountModel(models.Event{}, map[string]string{"noSuchThing": "1"})
+		assert.ErrorIs(t, err, ErrInvalidQuery)
+	})
+
+	t.Run("Test cursor pagination", func(t *testing.T) {
+		defer handleRecover(t.Name())
+
+		ids, err := testRepo.CreateMany(fakeEvents(7))
+		assert.NoError(t, err)
+		for _, id := range ids {
+			defer deleteEvent(id)
+		}
+
+		for _, sortBy := range []string{"", "-id", "startDate", "-startDate", "maxAttendees"} {
+			all, err := testRepo.QueryEvents(map[string]string{"sortBy": sortBy, "limit": "1000"})
+			assert.NoError(t, err)
+
+			// Walking the pages finds the same events, in the same order
+			var walked []models.Event
+			params := map[string]string{"sortBy": sortBy, "limit": "3"}
+			for {
+				page, err := testRepo.QueryEvents(params)
+				assert.NoError(t, err)
+				walked = append(walked, page...)
+				if len(page) < 3 {
+					break
+				}
+				params["cursor"], err = testRepo.NextCursor(page[len(page)-1], params)
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, all, walked, sortBy)
+		}
+
+		cursor, err := testRepo.NextCursor(models.Event{ID: ids[0]}, map[string]string{})
+		assert.NoError(t, err)
+		_, err = testRepo.QueryEvents(map[string]string{"sortBy": "name", "cursor": cursor})
+		assert.ErrorIs(t, err, ErrInvalidQuery)
+		_, err = testRepo.QueryEvents(map[string]string{"cursor": cursor, "offset": "3"})
+		assert.ErrorIs(t, err, ErrInvalidQuery)
+
+		// Cursors only work on the table, and with the key, they were made for
+		_, err = testRepo.QueryModel(models.User{}, map[string]string{"cursor": cursor})
+		assert.ErrorIs(t, err, ErrInvalidQuery)
+		other := &SqlRepo{DB: testDB, CursorKey: []byte("another-key")}
+		_, err = other.QueryEvents(map[string]string{"cursor": cursor})
+		assert.ErrorIs(t, err, ErrInvalidQuery)
+
+		// Nor can they be made from a write-only field
+		_, err = testRepo.NextCursor(models.User{ID: 1, Password: "secret"}, map[string]string{"sortBy": "password"})
+		assert.ErrorIs(t, err, ErrInvalidQuery)
 	})
 
 	t.Run("Test Delete", func(t *testing.T) {
@@ -102,15 +528,35 @@ func TestDBRepo(t *testing.T) {
 		u, err := testRepo.GetEventByID(1)
 		assert.NoError(t, err)
 
+		// Not at a version it has moved on from
+		stale := u
+		stale.Version--
+		assert.ErrorIs(t, testRepo.Delete(stale), ErrConflict)
+
 		err = testRepo.Delete(u)
 		assert.NoError(t, err)
+		assert.ErrorIs(t, testRepo.Delete(u), ErrNotFound)
 	})
 
 	t.Run("Test persistence of Delete", func(t *testing.T) {
 		defer handleRecover(t.Name())
 
 		_, err := testRepo.GetEventByID(1)
-		assert.Error(t, err)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("Test context cancellation", func(t *testing.T) {
+		defer handleRecover(t.Name())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := testRepo.QueryEventsContext(ctx, map[string]string{})
+		assert.ErrorIs(t, err, context.Canceled)
+
+		timed := &SqlRepo{DB: testDB, QueryTimeout: time.Millisecond}
+		_, err = timed.CreateContext(context.Background(), models.User{Email: gofakeit.Email(), Password: "password"})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
 	})
 
 	t.Run("Test QueryEvents", func(t *testing.T) {
@@ -163,6 +609,34 @@ func TestDBRepo(t *testing.T) {
 				queryParams: map[string]string{"maxAttendees": "75", "limit": "20"},
 				expectedLen: 15,
 			},
+			{
+				name:        "filter on end date",
+				queryParams: map[string]string{"name": "Test Event", "endDate_lt": inHours(30)},
+				expectedLen: 1,
+			},
+			{
+				name: "offset given with an unescaped plus",
+				queryParams: map[string]string{
+					"name":         "Test Event",
+					"startDate_lt": strings.ReplaceAll(time.Now().Add(30*time.Hour).In(time.FixedZone("JST", 9*60*60)).Format(time.RFC3339), "+", " "),
+				},
+				expectedLen: 1,
+			},
+			{
+				name:        "happening during",
+				queryParams: map[string]string{"name": "Test Event", "during": inHours(47) + "," + inHours(50)},
+				expectedLen: 1,
+			},
+			{
+				name:        "happening during; interval overlaps the start",
+				queryParams: map[string]string{"name": "Test Event", "during": inHours(20) + "," + inHours(24.5)},
+				expectedLen: 1,
+			},
+			{
+				name:        "happening during; malformed interval",
+				queryParams: map[string]string{"during": inHours(20)},
+				expectedErr: "invalid query: during must be two comma-separated times, got \"" + inHours(20) + "\"",
+			},
 		}
 
 		for _, tt := range tests {
@@ -190,6 +664,12 @@ func TestDBRepo(t *testing.T) {
 	})
 }
 
+// inHours formats the time the given number of hours from now as a query
+// parameter value.
+func inHours(h float64) string {
+	return time.Now().Add(time.Duration(h * float64(time.Hour))).Format(time.RFC3339)
+}
+
 func seedDBWithEvents(t *testing.T) {
 	defer handleRecover("seeding DB")
 	log.Println("Seeding DB")
@@ -200,6 +680,7 @@ func seedDBWithEvents(t *testing.T) {
 		Name:         "Test Event",
 		Description:  "At the manor hotel",
 		StartDate:    time.Now().Add(time.Hour * 24),
+		EndDate:      time.Now().Add(time.Hour * 26),
 		MaxAttendees: 100,
 	}
 	e2 := models.Event{
@@ -207,6 +688,7 @@ func seedDBWithEvents(t *testing.T) {
 		Name:         "Test Event",
 		Description:  "A different event with the same name",
 		StartDate:    time.Now().Add(time.Hour * 48),
+		AllDay:       true,
 		MaxAttendees: 50,
 	}
 	e3 := models.Event{
@@ -239,3 +721,307 @@ func seedDBWithEvents(t *testing.T) {
 	}
 	log.Println("DB Seeded")
 }
+
+func TestAttendance(t *testing.T) {
+	defer handleRecover(t.Name())
+
+	ownerID, err := testRepo.Create(models.User{Email: gofakeit.Email(), Password: "password"})
+	if err != nil {
+		t.Fatalf("Could not create owner: %s", err)
+	}
+	eventID, err := testRepo.Create(models.Event{
+		UserID:       ownerID,
+		Name:         "Small gathering",
+		Description:  "Only a handful of seats",
+		StartDate:    time.Now().Add(time.Hour * 24),
+		MaxAttendees: 5,
+	})
+	if err != nil {
+		t.Fatalf("Could not create event: %s", err)
+	}
+
+	userIDs := make([]int64, 20)
+	for i := range userIDs {
+		userIDs[i], err = testRepo.Create(models.User{Email: gofakeit.Email(), Password: "password"})
+		if err != nil {
+			t.Fatalf("Could not create user: %s", err)
+		}
+	}
+
+	t.Run("Concurrent RSVPs can't overfill the event", func(t *testing.T) {
+		var wg sync.WaitGroup
+		results := make(chan models.Attendee, len(userIDs))
+		for _, id := range userIDs {
+			wg.Add(1)
+			go func(id int64) {
+				defer wg.Done()
+				a, err := testRepo.RSVP(eventID, id)
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+					return
+				}
+				results <- a
+			}(id)
+		}
+		wg.Wait()
+		close(results)
+
+		var confirmed, waitlisted int
+		for a := range results {
+			switch a.Status {
+			case models.AttendeeConfirmed:
+				confirmed++
+			case models.AttendeeWaitlisted:
+				waitlisted++
+			}
+		}
+		assert.Equal(t, 5, confirmed)
+		assert.Equal(t, 15, waitlisted)
+
+		attendees, err := testRepo.QueryAttendees(map[string]string{
+			"eventId": strconv.FormatInt(eventID, 10),
+			"status":  models.AttendeeConfirmed,
+			"limit":   "50",
+		})
+		assert.NoError(t, err)
+		assert.Len(t, attendees, 5)
+	})
+
+	waitlist := func() []models.Attendee {
+		attendees, err := testRepo.QueryAttendees(map[string]string{
+			"eventId": strconv.FormatInt(eventID, 10),
+			"status":  models.AttendeeWaitlisted,
+			"sortBy":  "createdAt",
+			"limit":   "50",
+		})
+		assert.NoError(t, err)
+		return attendees
+	}
+
+	t.Run("RSVP twice", func(t *testing.T) {
+		attendees, err := testRepo.QueryAttendees(map[string]string{"eventId": strconv.FormatInt(eventID, 10)})
+		assert.NoError(t, err)
+
+		_, err = testRepo.RSVP(eventID, attendees[0].UserID)
+		assert.ErrorIs(t, err, ErrAlreadyAttending)
+
+		_, err = testRepo.RSVP(eventID, waitlist()[0].UserID)
+		assert.ErrorIs(t, err, ErrAlreadyAttending)
+	})
+
+	t.Run("Waitlist status", func(t *testing.T) {
+		queue := waitlist()
+		assert.Len(t, queue, 15)
+
+		length, position, err := testRepo.WaitlistStatus(eventID, queue[2].UserID)
+		assert.NoError(t, err)
+		assert.Equal(t, 15, length)
+		assert.Equal(t, 3, position)
+
+		length, position, err = testRepo.WaitlistStatus(eventID, ownerID)
+		assert.NoError(t, err)
+		assert.Equal(t, 15, length)
+		assert.Equal(t, 0, position)
+	})
+
+	t.Run("Cancelling promotes the first waitlisted user", func(t *testing.T) {
+		attendees, err := testRepo.QueryAttendees(map[string]string{
+			"eventId": strconv.FormatInt(eventID, 10),
+			"status":  models.AttendeeConfirmed,
+		})
+		assert.NoError(t, err)
+		next := waitlist()[0].UserID
+
+		leaving := attendees[0].UserID
+		assert.NoError(t, testRepo.CancelRSVP(eventID, leaving))
+		assert.ErrorIs(t, testRepo.CancelRSVP(eventID, leaving), ErrNotAttending)
+
+		promoted, err := testRepo.QueryAttendees(map[string]string{
+			"eventId": strconv.FormatInt(eventID, 10),
+			"userId":  strconv.FormatInt(next, 10),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, models.AttendeeConfirmed, promoted[0].Status)
+		assert.Len(t, waitlist(), 14)
+
+		// coming back puts them at the back of the queue
+		a, err := testRepo.RSVP(eventID, leaving)
+		assert.NoError(t, err)
+		assert.Equal(t, models.AttendeeWaitlisted, a.Status)
+		_, position, err := testRepo.WaitlistStatus(eventID, leaving)
+		assert.NoError(t, err)
+		assert.Equal(t, 15, position)
+	})
+
+	t.Run("Cancelling from the waitlist", func(t *testing.T) {
+		leaving := waitlist()[0].UserID
+		assert.NoError(t, testRepo.CancelRSVP(eventID, leaving))
+		assert.Len(t, waitlist(), 14)
+	})
+
+	t.Run("Raising the capacity seats the waitlist", func(t *testing.T) {
+		queue := waitlist()
+		e, err := testRepo.GetEventByID(eventID)
+		assert.NoError(t, err)
+		e.MaxAttendees += 3
+		assert.NoError(t, testRepo.Update(e))
+		assert.NoError(t, testRepo.PromoteWaitlist(eventID))
+		promoted := waitlist()
+		if assert.Len(t, promoted, len(queue)-3) {
+			assert.Equal(t, queue[3].UserID, promoted[0].UserID)
+		}
+
+		// Without a limit nobody has to wait
+		e, err = testRepo.GetEventByID(eventID)
+		assert.NoError(t, err)
+		e.MaxAttendees = 0
+		assert.NoError(t, testRepo.Update(e))
+		assert.NoError(t, testRepo.PromoteWaitlist(eventID))
+		assert.Empty(t, waitlist())
+
+		assert.ErrorIs(t, testRepo.PromoteWaitlist(999999), ErrNotFound)
+	})
+
+	t.Run("RSVP to a missing event", func(t *testing.T) {
+		_, err := testRepo.RSVP(999999, userIDs[0])
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestWithTx(t *testing.T) {
+	defer handleRecover(t.Name())
+	ctx := context.Background()
+	errAbort := errors.New("abort")
+
+	newUser := func() models.User {
+		return models.User{Email: gofakeit.Email(), Password: "password"}
+	}
+
+	t.Run("Commits on success", func(t *testing.T) {
+		var id int64
+		err := testRepo.WithTx(ctx, func(tx DBRepo) error {
+			var err error
+			id, err = tx.CreateContext(ctx, newUser())
+			return err
+		})
+		assert.NoError(t, err)
+
+		_, err = testRepo.GetUserByIDContext(ctx, id)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Rolls back on error", func(t *testing.T) {
+		var id int64
+		err := testRepo.WithTx(ctx, func(tx DBRepo) error {
+			var err error
+			id, err = tx.CreateContext(ctx, newUser())
+			if err != nil {
+				return err
+			}
+			return errAbort
+		})
+		assert.ErrorIs(t, err, errAbort)
+
+		_, err = testRepo.GetUserByIDContext(ctx, id)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("Rolls back on panic", func(t *testing.T) {
+		var id int64
+		assert.Panics(t, func() {
+			testRepo.WithTx(ctx, func(tx DBRepo) error {
+				id, _ = tx.CreateContext(ctx, newUser())
+				panic("boom")
+			})
+		})
+
+		_, err := testRepo.GetUserByIDContext(ctx, id)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("Nested calls join the outer transaction", func(t *testing.T) {
+		var id int64
+		err := testRepo.WithTx(ctx, func(tx DBRepo) error {
+			err := tx.WithTx(ctx, func(inner DBRepo) error {
+				var err error
+				id, err = inner.CreateContext(ctx, newUser())
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			// the inner work is visible here but not yet committed
+			if _, err := tx.GetUserByIDContext(ctx, id); err != nil {
+				return err
+			}
+			return errAbort
+		})
+		assert.ErrorIs(t, err, errAbort)
+
+		_, err = testRepo.GetUserByIDContext(ctx, id)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("Retries serialization failures", func(t *testing.T) {
+		retrying := &SqlRepo{DB: testDB, MaxTxRetries: 5}
+		opts := &sql.TxOptions{Isolation: sql.LevelSerializable}
+		retriesBefore := metricValue("retries")
+
+		// Both transactions count the users and then insert one, which can't
+		// be serialized if they overlap. The first attempts are held until
+		// both have read so that they are bound to conflict.
+		var read, done sync.WaitGroup
+		read.Add(2)
+		errs := make([]error, 2)
+		for i := range errs {
+			done.Add(1)
+			go func(i int) {
+				defer done.Done()
+				attempt := 0
+				errs[i] = retrying.WithTxOptions(ctx, opts, func(tx DBRepo) error {
+					attempt++
+					var count int
+					row := tx.(*SqlRepo).conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM users")
+					if err := row.Scan(&count); err != nil {
+						return err
+					}
+					if attempt == 1 {
+						read.Done()
+						read.Wait()
+					}
+					_, err := tx.CreateContext(ctx, newUser())
+					return err
+				})
+			}(i)
+		}
+		done.Wait()
+
+		assert.NoError(t, errs[0])
+		assert.NoError(t, errs[1])
+		assert.Greater(t, metricValue("retries"), retriesBefore)
+	})
+
+	t.Run("Isolation level", func(t *testing.T) {
+		opts := &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true}
+		err := testRepo.WithTxOptions(ctx, opts, func(tx DBRepo) error {
+			var level string
+			row := tx.(*SqlRepo).conn().QueryRowContext(ctx, "SHOW transaction_isolation")
+			if err := row.Scan(&level); err != nil {
+				return err
+			}
+			assert.Equal(t, "serializable", level)
+
+			_, err := tx.CreateContext(ctx, newUser())
+			return err
+		})
+		assert.Error(t, err, "writes should fail in a read-only transaction")
+	})
+}
+
+func metricValue(key string) int64 {
+	v, ok := txMetrics.Get(key).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
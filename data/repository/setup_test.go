@@ -50,6 +50,13 @@ func handleRecover(name string) {
 	}
 }
 
+// deleteEvent removes a test event at whatever version it has reached.
+func deleteEvent(id int64) {
+	if e, err := testRepo.GetEventByID(id); err == nil {
+		testRepo.Delete(e)
+	}
+}
+
 func TestMain(m *testing.M) {
 	var code int
 	defer func() {
@@ -99,7 +106,7 @@ func TestMain(m *testing.M) {
 		log.Fatalf("Could not connect to docker: %s", err)
 	}
 
-	testRepo = &SqlRepo{DB: testDB}
+	testRepo = &SqlRepo{DB: testDB, CursorKey: []byte("test-cursor-key")}
 	if err = testRepo.RunMigrations("test_db"); err != nil {
 		log.Fatal(err.Error())
 	}
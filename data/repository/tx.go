@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+)
+
+// dbtx is the subset of methods shared by *sql.DB and *sql.Tx that the
+// repository runs its queries through.
+type dbtx interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// conn returns the transaction the repo is bound to, or the connection pool if
+// it isn't bound to one.
+func (sr *SqlRepo) conn() dbtx {
+	if sr.tx != nil {
+		return sr.tx
+	}
+	return sr.DB
+}
+
+const (
+	txRetryBaseDelay = 5 * time.Millisecond
+	txRetryMaxDelay  = 500 * time.Millisecond
+)
+
+// txMetrics counts transaction retries and the conflicts behind them. It is
+// published through expvar as "transactions".
+var txMetrics = expvar.NewMap("transactions")
+
+// WithTx runs fn in a transaction with the driver's default isolation level.
+// See WithTxOptions.
+func (sr *SqlRepo) WithTx(ctx context.Context, fn func(tx DBRepo) error) error {
+	return sr.WithTxOptions(ctx, nil, fn)
+}
+
+// WithTxOptions runs fn in a transaction started with opts. Every call fn
+// makes through the DBRepo it is given runs in that transaction, which is
+// committed if fn returns nil and rolled back if it returns an error or
+// panics; the panic is then re-raised.
+//
+// If the transaction fails with a serialization failure or a deadlock, it is
+// rolled back and fn is run again in a new one, up to MaxTxRetries times, so fn
+// must be safe to repeat.
+//
+// Calling WithTx on a repo that is already in a transaction doesn't start a
+// new one: fn joins the outer transaction and opts are ignored, so the outer
+// call decides the isolation level, whether the work is committed and whether
+// it is retried.
+func (sr *SqlRepo) WithTxOptions(ctx context.Context, opts *sql.TxOptions, fn func(tx DBRepo) error) error {
+	return sr.inTx(ctx, opts, func(tx *SqlRepo) error {
+		return fn(tx)
+	})
+}
+
+func (sr *SqlRepo) inTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *SqlRepo) error) (err error) {
+	if sr.tx != nil {
+		return fn(sr)
+	}
+
+	ctx, cancel := sr.withTimeout(ctx)
+	defer cancel()
+	defer func() { err = contextError(ctx, err) }()
+
+	for attempt := 0; ; attempt++ {
+		err = sr.runTx(ctx, opts, fn)
+
+		reason, retryable := retryableTxError(err)
+		if !retryable {
+			return err
+		}
+		txMetrics.Add(reason, 1)
+
+		if attempt >= sr.MaxTxRetries {
+			txMetrics.Add("retries_exhausted", 1)
+			return fmt.Errorf("transaction failed after %d attempts: %w", attempt+1, err)
+		}
+		txMetrics.Add("retries", 1)
+
+		timer := time.NewTimer(txRetryDelay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// runTx makes a single attempt at running fn in a new transaction.
+func (sr *SqlRepo) runTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *SqlRepo) error) error {
+	tx, err := sr.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(&SqlRepo{DB: sr.DB, QueryTimeout: sr.QueryTimeout, MaxTxRetries: sr.MaxTxRetries, CursorKey: sr.CursorKey, tx: tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// retryableTxError reports whether err means the transaction lost out to a
+// concurrent one and is worth running again, along with the name of the
+// metric counting such failures.
+func retryableTxError(err error) (reason string, ok bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return "", false
+	}
+
+	switch pgErr.Code {
+	case pgerrcode.SerializationFailure:
+		return "serialization_failures", true
+	case pgerrcode.DeadlockDetected:
+		return "deadlocks", true
+	}
+	return "", false
+}
+
+// txRetryDelay returns how long to wait before the given retry. The delay
+// doubles with each attempt up to txRetryMaxDelay, and is picked at random
+// from that range so that transactions which collided once don't collide
+// again.
+func txRetryDelay(attempt int) time.Duration {
+	delay := txRetryMaxDelay
+	if attempt < 16 && txRetryBaseDelay<<attempt < txRetryMaxDelay {
+		delay = txRetryBaseDelay << attempt
+	}
+	return time.Duration(rand.Int63n(int64(delay))) + 1
+}